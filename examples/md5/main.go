@@ -3,8 +3,13 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"go-pipes/pkg/pipe"
 	"go-pipes/pkg/pipe/loader"
@@ -16,22 +21,80 @@ func main() {
 		dir      string
 		workers  int
 		quiet    bool
+		describe bool
+		stages   bool
+		drain    time.Duration
+		metrics  string
 	)
 	flag.StringVar(&yamlPath, "pipeline", "examples/md5/pipeline.yml", "Path to pipeline YAML")
 	flag.StringVar(&dir, "dir", ".", "Directory to walk as default")
 	flag.IntVar(&workers, "parallelism", 10, "MD5 hashing parallelism")
 	flag.BoolVar(&quiet, "quiet", false, "Suppress output")
+	flag.BoolVar(&describe, "describe", false, "List node types with their ports and exit")
+	flag.BoolVar(&stages, "stages", false, "Print pipeline stages in topological order and exit")
+	flag.DurationVar(&drain, "drain", 0, "On SIGINT/SIGTERM stop sources and let in-flight items drain for this long")
+	flag.StringVar(&metrics, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. localhost:9090")
 	flag.Parse()
 
 	// Build registry with builtins and CLI overrides as defaults
 	reg := loader.Builtins(dir, workers, quiet)
 
+	if describe {
+		for _, typ := range reg.Types() {
+			ports, err := reg.Ports(typ)
+			if err != nil {
+				log.Println("failed describing node type:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s:\n", typ)
+			for _, p := range ports {
+				fmt.Printf("  %s\n", p)
+			}
+		}
+		return
+	}
+
 	g, err := loader.LoadFromFile(yamlPath, reg)
 	if err != nil {
 		log.Println("failed loading pipeline:", err)
 		os.Exit(1)
 	}
-	if err := pipe.NewRunner(g).Run(context.Background()); err != nil {
+	if stages {
+		st, err := g.Stages()
+		if err != nil {
+			log.Println("failed ordering pipeline:", err)
+			os.Exit(1)
+		}
+		for i, nodes := range st {
+			fmt.Printf("stage %d:", i)
+			for _, n := range nodes {
+				fmt.Printf(" %s", n.ID())
+			}
+			fmt.Println()
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal falls through to the default handler and kills the process.
+		<-ctx.Done()
+		stop()
+	}()
+
+	runner := pipe.NewRunner(g)
+	runner.DrainTimeout = drain
+	if metrics != "" {
+		runner.Metrics = pipe.NewMetrics()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", runner.Metrics.Handler())
+		go func() {
+			if err := http.ListenAndServe(metrics, mux); err != nil {
+				log.Println("metrics server:", err)
+			}
+		}()
+	}
+	if err := runner.Run(ctx); err != nil {
 		log.Println("pipeline error:", err)
 		os.Exit(1)
 	}
@@ -3,3 +3,8 @@ module go-pipes
 go 1.25.2
 
 require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	golang.org/x/crypto v0.48.0
+	golang.org/x/sys v0.41.0 // indirect
+)
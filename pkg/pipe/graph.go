@@ -1,15 +1,38 @@
 package pipe
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 )
 
+// FanOut selects how an output port feeding several edges distributes items.
+type FanOut string
+
+const (
+	// Broadcast copies every item to each edge of the output.
+	Broadcast FanOut = "broadcast"
+	// Balance hands every item to exactly one edge, whichever is ready first.
+	Balance FanOut = "balance"
+)
+
+// EdgeOptions configures a single edge. Mode only matters when the output
+// port has more than one edge; an empty Mode defaults to Broadcast.
+// Feedback marks an edge that intentionally closes a cycle; it is left out
+// of topological ordering and cycle detection.
+type EdgeOptions struct {
+	Buffer   int
+	Mode     FanOut
+	Feedback bool
+}
+
 type edge struct {
-	from   Node
-	out    string
-	to     Node
-	in     string
-	buffer int
+	from Node
+	out  string
+	to   Node
+	in   string
+	EdgeOptions
 }
 
 // Graph holds nodes and their wiring.
@@ -26,33 +49,265 @@ func (g *Graph) Add(nodes ...Node) {
 
 // Connect wires from.out -> to.in with a buffered channel.
 func (g *Graph) Connect(from Node, outPort string, to Node, inPort string, buffer int) error {
+	return g.ConnectWith(from, outPort, to, inPort, EdgeOptions{Buffer: buffer})
+}
+
+// ConnectWith wires from.out -> to.in with a channel of its own, configured by opts.
+func (g *Graph) ConnectWith(from Node, outPort string, to Node, inPort string, opts EdgeOptions) error {
 	if from == nil || to == nil {
 		return fmt.Errorf("nil node in Connect")
 	}
-	if buffer < 0 {
-		buffer = 0
+	if opts.Buffer < 0 {
+		opts.Buffer = 0
 	}
-	g.edges = append(g.edges, edge{from: from, out: outPort, to: to, in: inPort, buffer: buffer})
+	g.edges = append(g.edges, edge{from: from, out: outPort, to: to, in: inPort, EdgeOptions: opts})
 	return nil
 }
 
-// materialize creates channels and assigns them to node ports.
-func (g *Graph) materialize() error {
-	// Map of fromNodeID:outPort to channel for potential multiple downstreams
+// PortError describes a wiring problem on a single node port.
+type PortError struct {
+	Node string
+	Port string
+	Msg  string
+}
+
+func (e *PortError) Error() string {
+	return fmt.Sprintf("%s.%s: %s", e.Node, e.Port, e.Msg)
+}
+
+// Validate checks every edge against the ports declared by its nodes.
+// It reports unknown ports, mismatched payload types, required inputs without
+// an edge, required outputs that nothing consumes and cycles not broken by a
+// feedback edge. All problems are returned joined together.
+func (g *Graph) Validate() error {
+	var errs []error
+	known := make(map[Node]struct{}, len(g.nodes))
+	ids := make(map[string]struct{}, len(g.nodes))
+	for _, n := range g.nodes {
+		if _, dup := ids[n.ID()]; dup {
+			errs = append(errs, fmt.Errorf("duplicate node id: %s", n.ID()))
+		}
+		ids[n.ID()] = struct{}{}
+		known[n] = struct{}{}
+	}
+
+	type key struct {
+		n    Node
+		port string
+	}
+	wiredIn := make(map[key]struct{})
+	wiredOut := make(map[key]struct{})
+	modes := make(map[key]FanOut)
+
+	for _, e := range g.edges {
+		for _, n := range []Node{e.from, e.to} {
+			if _, ok := known[n]; !ok {
+				errs = append(errs, fmt.Errorf("edge %s.%s -> %s.%s: node %s is not part of the graph", e.from.ID(), e.out, e.to.ID(), e.in, n.ID()))
+			}
+		}
+		var outSpec, inSpec PortSpec
+		var outOK, inOK bool
+		if specs := e.from.Ports(); len(specs) > 0 {
+			if outSpec, outOK = findPort(specs, e.out, PortOut); !outOK {
+				errs = append(errs, &PortError{Node: e.from.ID(), Port: e.out, Msg: "unknown output port"})
+			}
+		}
+		if specs := e.to.Ports(); len(specs) > 0 {
+			if inSpec, inOK = findPort(specs, e.in, PortIn); !inOK {
+				errs = append(errs, &PortError{Node: e.to.ID(), Port: e.in, Msg: "unknown input port"})
+			}
+		}
+		if outOK && inOK && !inSpec.Accepts(outSpec) {
+			errs = append(errs, &PortError{Node: e.to.ID(), Port: e.in, Msg: fmt.Sprintf("expects %s, but %s.%s emits %s", inSpec.TypeName(), e.from.ID(), e.out, outSpec.TypeName())})
+		}
+		switch e.Mode {
+		case "":
+		case Broadcast, Balance:
+			k := key{e.from, e.out}
+			if m, ok := modes[k]; ok && m != e.Mode {
+				errs = append(errs, &PortError{Node: e.from.ID(), Port: e.out, Msg: fmt.Sprintf("edges disagree on fan-out mode (%s vs %s)", m, e.Mode)})
+			}
+			modes[k] = e.Mode
+		default:
+			errs = append(errs, &PortError{Node: e.from.ID(), Port: e.out, Msg: fmt.Sprintf("unknown fan-out mode %q", e.Mode)})
+		}
+		wiredOut[key{e.from, e.out}] = struct{}{}
+		wiredIn[key{e.to, e.in}] = struct{}{}
+	}
+
+	for _, n := range g.nodes {
+		for _, p := range n.Ports() {
+			if !p.Required {
+				continue
+			}
+			switch p.Dir {
+			case PortIn:
+				if _, ok := wiredIn[key{n, p.Name}]; !ok {
+					errs = append(errs, &PortError{Node: n.ID(), Port: p.Name, Msg: "required input is not connected"})
+				}
+			case PortOut:
+				if _, ok := wiredOut[key{n, p.Name}]; !ok {
+					errs = append(errs, &PortError{Node: n.ID(), Port: p.Name, Msg: "output is not consumed by any edge"})
+				}
+			}
+		}
+	}
+	if _, err := g.Order(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// materialize creates one channel per edge and assigns channels to node
+// ports. Outputs feeding several edges are split by fan-out mode and inputs
+// fed by several edges get a merged channel; the returned functions pump
+// items through those splits and merges and must run alongside the nodes.
+// With m set, every edge also gets a relay that records its metrics.
+func (g *Graph) materialize(m *Metrics) ([]func(ctx context.Context), error) {
 	type key struct{ id, port string }
-	outChans := make(map[key]chan any)
+	type port struct {
+		n     Node
+		chans []chan any
+		mode  FanOut
+	}
+	outs := make(map[key]*port)
+	ins := make(map[key]*port)
+	var outOrder, inOrder []key
 
+	var pumps []func(ctx context.Context)
 	for _, e := range g.edges {
-		k := key{id: e.from.ID(), port: e.out}
-		ch, ok := outChans[k]
-		if !ok {
-			// create new channel for this output
-			ch = make(chan any, e.buffer)
-			e.from.SetOutput(e.out, ch)
-			outChans[k] = ch
+		ch := make(chan any, e.Buffer)
+		send := ch
+		if m != nil {
+			send = make(chan any)
+			es := m.addEdge(e, ch)
+			src := send
+			pumps = append(pumps, func(ctx context.Context) { es.relay(ctx, src) })
+		}
+
+		ok := key{id: e.from.ID(), port: e.out}
+		op, seen := outs[ok]
+		if !seen {
+			op = &port{n: e.from, mode: Broadcast}
+			outs[ok] = op
+			outOrder = append(outOrder, ok)
+		}
+		if e.Mode != "" {
+			op.mode = e.Mode
+		}
+		op.chans = append(op.chans, send)
+
+		ik := key{id: e.to.ID(), port: e.in}
+		ip, seen := ins[ik]
+		if !seen {
+			ip = &port{n: e.to}
+			ins[ik] = ip
+			inOrder = append(inOrder, ik)
+		}
+		ip.chans = append(ip.chans, ch)
+	}
+
+	for _, k := range outOrder {
+		op := outs[k]
+		if len(op.chans) == 1 {
+			op.n.SetOutput(k.port, op.chans[0])
+			continue
+		}
+		src := make(chan any)
+		op.n.SetOutput(k.port, src)
+		dsts := op.chans
+		if op.mode == Balance {
+			pumps = append(pumps, func(ctx context.Context) { balance(ctx, src, dsts) })
+		} else {
+			pumps = append(pumps, func(ctx context.Context) { broadcast(ctx, src, dsts) })
+		}
+	}
+	for _, k := range inOrder {
+		ip := ins[k]
+		if len(ip.chans) == 1 {
+			ip.n.SetInput(k.port, ip.chans[0])
+			continue
+		}
+		merged := make(chan any)
+		ip.n.SetInput(k.port, merged)
+		srcs := ip.chans
+		pumps = append(pumps, func(ctx context.Context) { merge(ctx, merged, srcs) })
+	}
+	return pumps, nil
+}
+
+// broadcast copies every item from src to each dst in turn, so the slowest
+// consumer sets the pace. All dsts are closed when src closes or ctx is done.
+func broadcast(ctx context.Context, src <-chan any, dsts []chan any) {
+	defer func() {
+		for _, d := range dsts {
+			close(d)
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-src:
+			if !ok {
+				return
+			}
+			for _, d := range dsts {
+				select {
+				case <-ctx.Done():
+					return
+				case d <- v:
+				}
+			}
+		}
+	}
+}
+
+// balance hands every item from src to a single dst, whichever accepts it
+// first. Each dst is closed once src closes or ctx is done.
+func balance(ctx context.Context, src <-chan any, dsts []chan any) {
+	var wg sync.WaitGroup
+	wg.Add(len(dsts))
+	for _, d := range dsts {
+		go func(d chan any) {
+			defer wg.Done()
+			defer close(d)
+			forward(ctx, d, src)
+		}(d)
+	}
+	wg.Wait()
+}
+
+// merge forwards items from every src into dst and closes dst once all
+// sources are closed or ctx is cancelled.
+func merge(ctx context.Context, dst chan any, srcs []chan any) {
+	defer close(dst)
+	var wg sync.WaitGroup
+	wg.Add(len(srcs))
+	for _, src := range srcs {
+		go func(src <-chan any) {
+			defer wg.Done()
+			forward(ctx, dst, src)
+		}(src)
+	}
+	wg.Wait()
+}
+
+// forward copies items from src to dst until src closes or ctx is done.
+func forward(ctx context.Context, dst chan<- any, src <-chan any) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-src:
+			if !ok {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case dst <- v:
+			}
 		}
-		// connect input
-		e.to.SetInput(e.in, ch)
 	}
-	return nil
 }
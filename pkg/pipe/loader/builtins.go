@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"go-pipes/pkg/pipe"
 	"go-pipes/pkg/pipe/nodes"
+	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
 type Defaults struct {
@@ -56,6 +60,109 @@ func getStringList(cfg map[string]any, key string, def []string) []string {
 	return def
 }
 
+func getDuration(cfg map[string]any, key string, def time.Duration) (time.Duration, error) {
+	switch v := cfg[key].(type) {
+	case string:
+		if v == "" {
+			return def, nil
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", key, err)
+		}
+		return d, nil
+	case int:
+		return time.Duration(v) * time.Millisecond, nil
+	case float64:
+		return time.Duration(v * float64(time.Millisecond)), nil
+	}
+	return def, nil
+}
+
+// getTime reads an absolute time (RFC 3339, "2006-01-02 15:04:05" or
+// "2006-01-02", local time) or a duration meaning that long before now.
+func getTime(cfg map[string]any, key string) (time.Time, error) {
+	switch v := cfg[key].(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		if v == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %q is neither a time nor a duration", key, v)
+		}
+		return time.Now().Add(-d), nil
+	case nil:
+		return time.Time{}, nil
+	}
+	return time.Time{}, fmt.Errorf("%s: expected a time or a duration", key)
+}
+
+// getErrorPolicy reads the on_error setting, either a bare policy name or
+// a map with policy, attempts, backoff, max_backoff, then and port.
+func getErrorPolicy(cfg map[string]any) (pipe.ErrorPolicy, error) {
+	var p pipe.ErrorPolicy
+	switch v := cfg["on_error"].(type) {
+	case nil:
+		return p, nil
+	case string:
+		p.Mode = pipe.ErrorMode(v)
+	case map[string]any:
+		p.Mode = pipe.ErrorMode(getString(v, "policy", ""))
+		p.Attempts = getInt(v, "attempts", 0)
+		p.Then = pipe.ErrorMode(getString(v, "then", ""))
+		p.Port = getString(v, "port", "")
+		var err error
+		if p.Backoff, err = getDuration(v, "backoff", 0); err != nil {
+			return p, fmt.Errorf("on_error: %w", err)
+		}
+		if p.MaxBackoff, err = getDuration(v, "max_backoff", 0); err != nil {
+			return p, fmt.Errorf("on_error: %w", err)
+		}
+	default:
+		return p, fmt.Errorf("on_error: expected a policy name or a map")
+	}
+	return p, p.Validate()
+}
+
+// getRotation reads the rotate map of file_sink: max_bytes, max_records,
+// interval, gzip and keep.
+func getRotation(cfg map[string]any) (nodes.Rotation, error) {
+	var r nodes.Rotation
+	switch v := cfg["rotate"].(type) {
+	case nil:
+		return r, nil
+	case map[string]any:
+		r.MaxBytes = int64(getInt(v, "max_bytes", 0))
+		r.MaxRecords = getInt(v, "max_records", 0)
+		var err error
+		if r.Interval, err = getDuration(v, "interval", 0); err != nil {
+			return r, fmt.Errorf("rotate: %w", err)
+		}
+		r.Gzip = getBool(v, "gzip", false)
+		r.Keep = getInt(v, "keep", 0)
+	default:
+		return r, fmt.Errorf("rotate: expected a map")
+	}
+	if r.MaxBytes < 0 || r.MaxRecords < 0 || r.Interval < 0 || r.Keep < 0 {
+		return r, fmt.Errorf("rotate: limits must not be negative")
+	}
+	if r.MaxBytes == 0 && r.MaxRecords == 0 && r.Interval == 0 {
+		return r, fmt.Errorf("rotate: set max_bytes, max_records or interval")
+	}
+	return r, nil
+}
+
 func builtinFactories() map[string]BuiltinFactory {
 	return map[string]BuiltinFactory{
         "stdin_source": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
@@ -79,6 +186,42 @@ func builtinFactories() map[string]BuiltinFactory {
 			workers := getInt(cfg, "workers", 1)
 			n := nodes.NewFileWalker(id, dirs...)
 			n.Workers = workers
+			n.Include = getStringList(cfg, "include", nil)
+			n.Exclude = getStringList(cfg, "exclude", nil)
+			for _, p := range append(slices.Clone(n.Include), n.Exclude...) {
+				if _, err := nodes.CompileGlob(p); err != nil {
+					return nil, fmt.Errorf("%s: %w", id, err)
+				}
+			}
+			n.MinSize = int64(getInt(cfg, "min_size", 0))
+			n.MaxSize = int64(getInt(cfg, "max_size", 0))
+			var err error
+			if n.ModifiedAfter, err = getTime(cfg, "modified_after"); err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			if n.ModifiedBefore, err = getTime(cfg, "modified_before"); err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n.MaxDepth = getInt(cfg, "max_depth", 0)
+			n.SkipHidden = getBool(cfg, "skip_hidden", false)
+			n.IgnoreFiles = getStringList(cfg, "ignore_files", nil)
+			switch emit := getString(cfg, "emit", "path"); emit {
+			case "path":
+			case "info":
+				n.EmitInfo = true
+			default:
+				return nil, fmt.Errorf("%s: unknown emit %q (want path or info)", id, emit)
+			}
+			n.Symlinks = getString(cfg, "symlinks", nodes.SymlinksFollow)
+			switch n.Symlinks {
+			case nodes.SymlinksFollow, nodes.SymlinksSkip, nodes.SymlinksEmitLink:
+			default:
+				return nil, fmt.Errorf("%s: unknown symlinks policy %q", id, n.Symlinks)
+			}
+			n.OneFilesystem = getBool(cfg, "one_filesystem", false)
+			if n.OnError, err = getErrorPolicy(cfg); err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
 			return n, nil
 		},
 		"md5_hasher": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
@@ -86,7 +229,30 @@ func builtinFactories() map[string]BuiltinFactory {
 				return nil, fmt.Errorf("empty id")
 			}
 			workers := getInt(cfg, "workers", d.Workers)
-			return nodes.NewMD5Hasher(id, workers), nil
+			policy, err := getErrorPolicy(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n := nodes.NewMD5Hasher(id, workers)
+			n.OnError = policy
+			return n, nil
+		},
+		"hasher": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
+			if id == "" {
+				return nil, fmt.Errorf("empty id")
+			}
+			workers := getInt(cfg, "workers", d.Workers)
+			algorithms := getStringList(cfg, "algorithms", []string{"sha256"})
+			policy, err := getErrorPolicy(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n, err := nodes.NewHasher(id, workers, algorithms...)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n.OnError = policy
+			return n, nil
 		},
 		"printer": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
 			if id == "" {
@@ -96,6 +262,11 @@ func builtinFactories() map[string]BuiltinFactory {
 			workers := getInt(cfg, "workers", 1)
 			n := nodes.NewPrinter(id, quiet)
 			n.Workers = workers
+			n.Format = getString(cfg, "format", nodes.FormatText)
+			if _, err := nodes.NewEncoder(n.Format); err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n.Warnings = os.Stderr
 			return n, nil
 		},
 		"file_sink": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
@@ -107,6 +278,128 @@ func builtinFactories() map[string]BuiltinFactory {
 			workers := getInt(cfg, "workers", 1)
 			n := nodes.NewFileSink(id, path, append)
 			n.Workers = workers
+			n.Atomic = getBool(cfg, "atomic", false)
+			if n.Atomic && n.Append {
+				return nil, fmt.Errorf("%s: atomic and append cannot be combined", id)
+			}
+			n.Format = getString(cfg, "format", nodes.FormatText)
+			if _, err := nodes.NewEncoder(n.Format); err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n.Compress = getString(cfg, "compress", "")
+			codec, err := nodes.CompressionFor(path, n.Compress)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			if n.Rotation, err = getRotation(cfg); err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			if n.Rotation.Gzip && codec != nodes.CompressNone {
+				return nil, fmt.Errorf("%s: rotate.gzip with %s output would compress twice", id, codec)
+			}
+			if cfg["rotate"] != nil {
+				if n.Atomic || n.Append {
+					return nil, fmt.Errorf("%s: rotate cannot be combined with atomic or append", id)
+				}
+				if _, err := nodes.ParseSegmentTemplate(path); err != nil {
+					return nil, fmt.Errorf("%s: path: %w", id, err)
+				}
+			}
+			n.Warnings = os.Stderr
+			return n, nil
+		},
+		"progress": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
+			if id == "" {
+				return nil, fmt.Errorf("empty id")
+			}
+			n := nodes.NewProgress(id)
+			var err error
+			if n.Interval, err = getDuration(cfg, "interval", n.Interval); err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n.Mode = getString(cfg, "mode", n.Mode)
+			switch n.Mode {
+			case "auto", "tty", "log":
+			default:
+				return nil, fmt.Errorf("%s: unknown progress mode %q", id, n.Mode)
+			}
+			return n, nil
+		},
+		"checksum_manifest_source": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
+			if id == "" {
+				return nil, fmt.Errorf("empty id")
+			}
+			paths := getStringList(cfg, "path", []string{"-"})
+			n := nodes.NewChecksumManifestSource(id, paths...)
+			n.Algorithm = getString(cfg, "algorithm", "")
+			n.Base = getString(cfg, "base", "")
+			n.Warnings = os.Stderr
+			return n, nil
+		},
+		"verifier": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
+			if id == "" {
+				return nil, fmt.Errorf("empty id")
+			}
+			return nodes.NewVerifier(id), nil
+		},
+		"dedup": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
+			if id == "" {
+				return nil, fmt.Errorf("empty id")
+			}
+			n := nodes.NewDedup(id, getInt(cfg, "workers", d.Workers))
+			n.Algorithm = getString(cfg, "algorithm", n.Algorithm)
+			if !slices.Contains(nodes.HashAlgorithms(), n.Algorithm) {
+				return nil, fmt.Errorf("%s: unknown hash algorithm %q", id, n.Algorithm)
+			}
+			n.PartialSize = int64(getInt(cfg, "partial_kb", 4)) * 1024
+			if n.PartialSize <= 0 {
+				return nil, fmt.Errorf("%s: partial_kb must be positive", id)
+			}
+			n.MinSize = int64(getInt(cfg, "min_size", int(n.MinSize)))
+			n.Action = getString(cfg, "action", n.Action)
+			switch n.Action {
+			case nodes.DedupReport, nodes.DedupHardlink, nodes.DedupDeleteButOne:
+			default:
+				return nil, fmt.Errorf("%s: unknown dedup action %q", id, n.Action)
+			}
+			n.DryRun = getBool(cfg, "dry_run", true)
+			policy, err := getErrorPolicy(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n.OnError = policy
+			return n, nil
+		},
+		"sort": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
+			if id == "" {
+				return nil, fmt.Errorf("empty id")
+			}
+			n := nodes.NewSort(id)
+			n.Key = getString(cfg, "key", n.Key)
+			switch n.Key {
+			case nodes.SortByPath, nodes.SortByText:
+			default:
+				return nil, fmt.Errorf("%s: unknown sort key %q (want path or text)", id, n.Key)
+			}
+			n.MaxItems = getInt(cfg, "max_items", n.MaxItems)
+			n.TempDir = getString(cfg, "temp_dir", "")
+			return n, nil
+		},
+		"archive_walker": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
+			if id == "" {
+				return nil, fmt.Errorf("empty id")
+			}
+			n := nodes.NewArchiveWalker(id, getInt(cfg, "workers", d.Workers))
+			n.MaxDepth = getInt(cfg, "max_depth", n.MaxDepth)
+			if n.MaxDepth < 1 {
+				return nil, fmt.Errorf("%s: max_depth must be at least 1", id)
+			}
+			n.TempDir = getString(cfg, "temp_dir", "")
+			policy, err := getErrorPolicy(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			n.OnError = policy
 			return n, nil
 		},
 		"tee": func(id string, cfg map[string]any, d Defaults) (pipe.Node, error) {
@@ -126,8 +419,15 @@ func Builtins(dirDefault string, workersDefault int, quietDefault bool) *Registr
 		t := typ
 		f := factory
 		reg.Register(t, func(spec NodeSpec) (pipe.Node, error) {
+			if _, ok := spec.Config["on_error"]; ok && !slices.Contains(errorPolicyTypes, t) {
+				return nil, fmt.Errorf("%s: on_error is not supported by %s nodes (supported: %s)", spec.ID, t, strings.Join(errorPolicyTypes, ", "))
+			}
 			return f(spec.ID, spec.Config, defaults)
 		})
 	}
 	return reg
 }
+
+// errorPolicyTypes are the builtin node types that apply an on_error
+// policy; the key is rejected on any other type rather than ignored.
+var errorPolicyTypes = []string{"archive_walker", "dedup", "file_walker", "hasher", "md5_hasher"}
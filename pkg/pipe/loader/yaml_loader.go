@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -24,9 +25,11 @@ type NodeSpec struct {
 }
 
 type EdgeSpec struct {
-	From   string `yaml:"from"` // nodeID.out
-	To     string `yaml:"to"`   // nodeID.in
-	Buffer int    `yaml:"buffer"`
+	From     string `yaml:"from"` // nodeID.out
+	To       string `yaml:"to"`   // nodeID.in
+	Buffer   int    `yaml:"buffer"`
+	Mode     string `yaml:"mode"`     // broadcast|balance, for outputs with several edges
+	Feedback bool   `yaml:"feedback"` // edge intentionally closes a cycle
 }
 
 // NodeFactory creates a pipe.Node from a NodeSpec.
@@ -48,6 +51,26 @@ func (r *Registry) Build(spec NodeSpec) (pipe.Node, error) {
 	return f(spec)
 }
 
+// Types lists the registered node types in sorted order.
+func (r *Registry) Types() []string {
+	types := make([]string, 0, len(r.factories))
+	for t := range r.factories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Ports returns the port declarations of a node type by building a
+// throwaway instance with an empty config. The node is never started.
+func (r *Registry) Ports(nodeType string) ([]pipe.PortSpec, error) {
+	n, err := r.Build(NodeSpec{ID: nodeType, Type: nodeType, Config: map[string]any{}})
+	if err != nil {
+		return nil, err
+	}
+	return n.Ports(), nil
+}
+
 // LoadFromReader reads YAML, constructs a Graph using provided registry.
 func LoadFromReader(r io.Reader, reg *Registry) (*pipe.Graph, error) {
 	var spec PipelineSpec
@@ -91,7 +114,8 @@ func LoadFromReader(r io.Reader, reg *Registry) (*pipe.Graph, error) {
 		if from == nil || to == nil {
 			return nil, fmt.Errorf("unknown node id in edge: %q -> %q", es.From, es.To)
 		}
-		if err := g.Connect(from, fromPort, to, toPort, es.Buffer); err != nil {
+		opts := pipe.EdgeOptions{Buffer: es.Buffer, Mode: pipe.FanOut(es.Mode), Feedback: es.Feedback}
+		if err := g.ConnectWith(from, fromPort, to, toPort, opts); err != nil {
 			return nil, err
 		}
 	}
@@ -0,0 +1,257 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics collects runtime counters for the nodes and edges of a run.
+// Attach it to a Runner before Run; read it at any time with Snapshot or
+// serve it in Prometheus text format with Handler.
+type Metrics struct {
+	mu    sync.Mutex
+	nodes []*nodeStats
+	edges []*edgeStats
+}
+
+func NewMetrics() *Metrics { return &Metrics{} }
+
+// NodeStats is a point-in-time view of one node.
+// Processing is the time spent inside Start so far.
+type NodeStats struct {
+	ID         string
+	ItemsIn    uint64
+	ItemsOut   uint64
+	Errors     uint64
+	Processing time.Duration
+	Running    bool
+}
+
+// EdgeStats is a point-in-time view of one edge. Len and Cap describe the
+// channel occupancy; Blocked is the total time the upstream side waited
+// because the channel was full.
+type EdgeStats struct {
+	From    string
+	To      string
+	Len     int
+	Cap     int
+	Sent    uint64
+	Blocked time.Duration
+}
+
+// Snapshot holds the stats of every node and edge, in graph order.
+type Snapshot struct {
+	Nodes []NodeStats
+	Edges []EdgeStats
+}
+
+type nodeStats struct {
+	n       Node
+	started atomic.Int64 // unix nanos, 0 before Start
+	stopped atomic.Int64 // unix nanos, 0 while running
+	failed  atomic.Uint64
+}
+
+type edgeStats struct {
+	e       edge
+	ch      chan any
+	recv    atomic.Uint64 // taken from the upstream side
+	sent    atomic.Uint64 // put into the edge channel
+	blocked atomic.Int64  // nanoseconds
+}
+
+func (m *Metrics) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes = nil
+	m.edges = nil
+}
+
+func (m *Metrics) addNode(n Node) *nodeStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns := &nodeStats{n: n}
+	m.nodes = append(m.nodes, ns)
+	return ns
+}
+
+func (m *Metrics) addEdge(e edge, ch chan any) *edgeStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	es := &edgeStats{e: e, ch: ch}
+	m.edges = append(m.edges, es)
+	return es
+}
+
+func (ns *nodeStats) start() { ns.started.Store(time.Now().UnixNano()) }
+
+func (ns *nodeStats) stop(err error) {
+	if err != nil {
+		ns.failed.Add(1)
+	}
+	ns.stopped.Store(time.Now().UnixNano())
+}
+
+// relay moves items from the upstream side to the edge channel, counting
+// them and timing how long each send waits for room.
+func (es *edgeStats) relay(ctx context.Context, src <-chan any) {
+	defer close(es.ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-src:
+			if !ok {
+				return
+			}
+			es.recv.Add(1)
+			select {
+			case es.ch <- v:
+			default:
+				t := time.Now()
+				select {
+				case <-ctx.Done():
+					return
+				case es.ch <- v:
+				}
+				es.blocked.Add(int64(time.Since(t)))
+			}
+			es.sent.Add(1)
+		}
+	}
+}
+
+// Snapshot returns the current counters. Items out of a broadcast port are
+// counted once per item, not once per edge.
+func (m *Metrics) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type port struct {
+		n    Node
+		name string
+	}
+	outs := make(map[port][]*edgeStats)
+	var snap Snapshot
+	for _, es := range m.edges {
+		outs[port{es.e.from, es.e.out}] = append(outs[port{es.e.from, es.e.out}], es)
+		snap.Edges = append(snap.Edges, EdgeStats{
+			From:    es.e.from.ID() + "." + es.e.out,
+			To:      es.e.to.ID() + "." + es.e.in,
+			Len:     len(es.ch),
+			Cap:     cap(es.ch),
+			Sent:    es.sent.Load(),
+			Blocked: time.Duration(es.blocked.Load()),
+		})
+	}
+
+	now := time.Now().UnixNano()
+	for _, ns := range m.nodes {
+		st := NodeStats{ID: ns.n.ID(), Errors: ns.failed.Load()}
+		if r, ok := ns.n.(interface{ Rejects() uint64 }); ok {
+			st.Errors += r.Rejects()
+		}
+		if started := ns.started.Load(); started != 0 {
+			end := ns.stopped.Load()
+			if end == 0 {
+				end = now
+				st.Running = true
+			}
+			st.Processing = time.Duration(end - started)
+		}
+		for _, es := range m.edges {
+			if es.e.to == ns.n {
+				if got := es.sent.Load(); got > uint64(len(es.ch)) {
+					st.ItemsIn += got - uint64(len(es.ch))
+				}
+			}
+		}
+		for p, edges := range outs {
+			if p.n != ns.n {
+				continue
+			}
+			var sum, most uint64
+			balanced := false
+			for _, es := range edges {
+				got := es.recv.Load()
+				sum += got
+				most = max(most, got)
+				balanced = balanced || es.e.Mode == Balance
+			}
+			if balanced {
+				st.ItemsOut += sum
+			} else {
+				st.ItemsOut += most
+			}
+		}
+		snap.Nodes = append(snap.Nodes, st)
+	}
+	return snap
+}
+
+// WritePrometheus writes the current snapshot in Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	snap := m.Snapshot()
+	var b strings.Builder
+	metric := func(name, typ, help string, each func()) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		each()
+	}
+	node := func(name string, val func(NodeStats) string) func() {
+		return func() {
+			for _, st := range snap.Nodes {
+				fmt.Fprintf(&b, "%s{node=%q} %s\n", name, st.ID, val(st))
+			}
+		}
+	}
+	edge := func(name string, val func(EdgeStats) string) func() {
+		return func() {
+			for _, st := range snap.Edges {
+				fmt.Fprintf(&b, "%s{from=%q,to=%q} %s\n", name, st.From, st.To, val(st))
+			}
+		}
+	}
+	u := func(v uint64) string { return fmt.Sprint(v) }
+	secs := func(d time.Duration) string { return fmt.Sprint(d.Seconds()) }
+
+	metric("gopipes_node_items_in_total", "counter", "Items received by the node.",
+		node("gopipes_node_items_in_total", func(s NodeStats) string { return u(s.ItemsIn) }))
+	metric("gopipes_node_items_out_total", "counter", "Items emitted by the node.",
+		node("gopipes_node_items_out_total", func(s NodeStats) string { return u(s.ItemsOut) }))
+	metric("gopipes_node_errors_total", "counter", "Node failures and items rejected by the error policy.",
+		node("gopipes_node_errors_total", func(s NodeStats) string { return u(s.Errors) }))
+	metric("gopipes_node_processing_seconds_total", "counter", "Time spent inside Start.",
+		node("gopipes_node_processing_seconds_total", func(s NodeStats) string { return secs(s.Processing) }))
+	metric("gopipes_node_running", "gauge", "Whether the node is still running.",
+		node("gopipes_node_running", func(s NodeStats) string {
+			if s.Running {
+				return "1"
+			}
+			return "0"
+		}))
+	metric("gopipes_edge_queue_length", "gauge", "Items waiting in the edge buffer.",
+		edge("gopipes_edge_queue_length", func(s EdgeStats) string { return fmt.Sprint(s.Len) }))
+	metric("gopipes_edge_queue_capacity", "gauge", "Edge buffer size.",
+		edge("gopipes_edge_queue_capacity", func(s EdgeStats) string { return fmt.Sprint(s.Cap) }))
+	metric("gopipes_edge_items_total", "counter", "Items sent over the edge.",
+		edge("gopipes_edge_items_total", func(s EdgeStats) string { return u(s.Sent) }))
+	metric("gopipes_edge_send_blocked_seconds_total", "counter", "Time the upstream side waited for room in the edge buffer.",
+		edge("gopipes_edge_send_blocked_seconds_total", func(s EdgeStats) string { return secs(s.Blocked) }))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Handler serves the metrics in Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.WritePrometheus(w)
+	})
+}
@@ -3,6 +3,7 @@ package pipe
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 )
 
 // Node represents a processing unit in the pipeline graph.
@@ -13,7 +14,9 @@ import (
 type Node interface {
 	ID() string
 
-	// Port metadata
+	// Port metadata, declared statically by the node type.
+	// Nodes that return no ports are not checked by Graph.Validate.
+	Ports() []PortSpec
 	InPorts() []string
 	OutPorts() []string
 
@@ -28,31 +31,34 @@ type Node interface {
 	CloseOutputs()
 }
 
+// Closer is implemented by nodes holding resources that outlive Start, such
+// as files that items they emitted still refer to. Runner calls Close once
+// every node has returned, before Run returns; callers driving nodes
+// themselves must do the same.
+type Closer interface {
+	Close() error
+}
+
 // BaseNode provides common storage for ports and a helper to close all outputs.
+// PortSpecs holds the static port declarations of the embedding node type.
+// OnError is the item-level error policy for nodes that support one; see Reject.
 type BaseNode struct {
-	IDValue string
-	In      map[string]<-chan any
-	Out     map[string]chan any
-	once    sync.Once
+	IDValue   string
+	PortSpecs []PortSpec
+	OnError   ErrorPolicy
+	In        map[string]<-chan any
+	Out       map[string]chan any
+	once      sync.Once
+	rejects   atomic.Uint64
 }
 
 func (b *BaseNode) ID() string { return b.IDValue }
 
-func (b *BaseNode) InPorts() []string {
-	ports := make([]string, 0, len(b.In))
-	for p := range b.In {
-		ports = append(ports, p)
-	}
-	return ports
-}
+func (b *BaseNode) Ports() []PortSpec { return b.OnError.Ports(b.PortSpecs) }
 
-func (b *BaseNode) OutPorts() []string {
-	ports := make([]string, 0, len(b.Out))
-	for p := range b.Out {
-		ports = append(ports, p)
-	}
-	return ports
-}
+func (b *BaseNode) InPorts() []string { return portNames(b.Ports(), PortIn) }
+
+func (b *BaseNode) OutPorts() []string { return portNames(b.Ports(), PortOut) }
 
 func (b *BaseNode) SetInput(port string, ch <-chan any) {
 	if b.In == nil {
@@ -84,6 +90,9 @@ func (b *BaseNode) SetOutput(port string, ch chan any) {
 	b.Out[port] = ch
 }
 
+// Rejects returns how many items the node handed to its error policy.
+func (b *BaseNode) Rejects() uint64 { return b.rejects.Load() }
+
 func (b *BaseNode) CloseOutputs() {
 	b.once.Do(func() {
 		for _, ch := range b.Out {
@@ -0,0 +1,509 @@
+package nodes
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go-pipes/pkg/pipe"
+)
+
+// ArchiveSep separates an archive from the name of an entry inside it in
+// virtual paths, e.g. bundle.zip!/lib/a.so or a.tar.gz!/inner.zip!/b.txt.
+const ArchiveSep = "!/"
+
+// ArchiveEntry is a regular file inside an archive found by ArchiveWalker.
+// Hashers read its content from the archive; nodes that take file paths
+// accept it in place of a plain string.
+type ArchiveEntry struct {
+	Path    string // virtual path, see ArchiveSep
+	Archive string // the archive file on disk
+	Name    string // name inside the innermost archive
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+	Depth   int // archives opened to reach the entry, 1 for Archive's own
+	// SpoolDir is the walker's TempDir, used again for spool files when
+	// the entry has to be resolved by Path
+	SpoolDir string
+
+	// where the content is stored; unset after a round trip through a
+	// sort spill file, in which case Path is resolved again
+	loc entryLoc
+}
+
+func (e ArchiveEntry) String() string { return e.Path }
+
+// entryLoc locates the content of an entry as a byte range of a file on
+// disk, which is the archive itself or a spool file holding a decompressed
+// copy of it.
+type entryLoc struct {
+	file   string
+	off    int64
+	size   int64
+	method uint16 // zip.Store or zip.Deflate; tar members are stored
+}
+
+// ArchiveWalker opens the tar and zip archives among its input paths and
+// emits their regular files as ArchiveEntry. Archives inside archives are
+// opened as well, up to MaxDepth layers; deeper ones are emitted as
+// entries. Other paths are passed through unchanged, so the walker can sit
+// between a file_walker and a hasher.
+//
+// Zip archives and plain tar files are read in place. Compressed tar
+// files, and nested archives that are compressed inside their parent, are
+// decompressed once into spool files in TempDir, which are removed when
+// the run ends.
+type ArchiveWalker struct {
+	pipe.BaseNode
+	Workers  int // archives opened in parallel
+	MaxDepth int
+	TempDir  string // directory for spool files; empty means os.TempDir
+
+	mu     sync.Mutex
+	spools []string
+}
+
+var archiveWalkerPorts = []pipe.PortSpec{
+	{Name: "paths", Dir: pipe.PortIn, Required: true, Doc: "string paths or FileInfo; archives are detected by extension"},
+	{Name: "entries", Dir: pipe.PortOut, Required: true, Doc: "ArchiveEntry for archive contents, other paths unchanged"},
+}
+
+func NewArchiveWalker(id string, workers int) *ArchiveWalker {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &ArchiveWalker{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: archiveWalkerPorts}, Workers: workers, MaxDepth: 3}
+}
+
+// archiveKind tells from a file name whether it is a zip or tar archive.
+// Tar archives may be compressed with any codec decompress reads.
+func archiveKind(name string) string {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".jar", ".war", ".whl"} {
+		if strings.HasSuffix(name, ext) {
+			return "zip"
+		}
+	}
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tbz", ".tar.zz"} {
+		if strings.HasSuffix(name, ext) {
+			return "tar"
+		}
+	}
+	return ""
+}
+
+func (n *ArchiveWalker) Start(ctx context.Context) error {
+	defer n.CloseOutputs()
+	in, _ := n.GetInput("paths")
+	out, _ := n.GetOutput("entries")
+	if in == nil || out == nil {
+		return nil
+	}
+	send := func(ch chan any, v any) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- v:
+		}
+		return nil
+	}
+	s := &archiveScan{
+		maxDepth: n.MaxDepth,
+		tempDir:  n.TempDir,
+		spool:    n.spool,
+		emit:     func(e ArchiveEntry) error { return send(out, e) },
+		fail: func(path string, err error) error {
+			return n.Reject(ctx, path, 1, WalkError{Path: path, Err: err})
+		},
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		failErr error
+	)
+	workers := max(n.Workers, 1)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var v any
+				var ok bool
+				select {
+				case <-ctx.Done():
+					return
+				case v, ok = <-in:
+					if !ok {
+						return
+					}
+				}
+				p, isPath := itemPath(v)
+				var err error
+				if kind := archiveKind(p); isPath && kind != "" {
+					err = s.archive(ctx, p, kind)
+				} else {
+					err = send(out, v)
+				}
+				if err != nil {
+					errOnce.Do(func() { failErr = err })
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return failErr
+}
+
+// spool copies r into a new spool file.
+func (n *ArchiveWalker) spool(r io.Reader) (entryLoc, error) {
+	f, err := os.CreateTemp(n.TempDir, "gopipes-archive-")
+	if err != nil {
+		return entryLoc{}, err
+	}
+	n.mu.Lock()
+	n.spools = append(n.spools, f.Name())
+	n.mu.Unlock()
+	size, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return entryLoc{file: f.Name(), size: size}, err
+}
+
+// Close removes the spool files. Entries are read after the walker is
+// done, so they live until the whole run ends; see pipe.Closer.
+func (n *ArchiveWalker) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var errs []error
+	for _, name := range n.spools {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	n.spools = nil
+	return errors.Join(errs...)
+}
+
+// archiveScan walks archives and the archives nested in them.
+type archiveScan struct {
+	maxDepth int
+	tempDir  string
+	// only, if set, is the virtual path of a single entry; just the
+	// archives on its way are opened
+	only  string
+	spool func(io.Reader) (entryLoc, error)
+	emit  func(ArchiveEntry) error
+	fail  func(path string, err error) error
+}
+
+// archive walks the archive file.
+func (s *archiveScan) archive(ctx context.Context, file, kind string) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return s.fail(file, err)
+	}
+	return s.walk(ctx, ArchiveEntry{Path: file, Archive: file, loc: entryLoc{file: file, size: fi.Size()}}, kind)
+}
+
+// walk visits the entries of the archive stored at parent.loc, whose
+// virtual path is parent.Path. An archive that cannot be read goes to
+// fail; what emit and fail return is returned as is.
+func (s *archiveScan) walk(ctx context.Context, parent ArchiveEntry, kind string) error {
+	var visitErr error
+	visit := func(e ArchiveEntry) error {
+		visitErr = s.visit(ctx, parent, e)
+		return visitErr
+	}
+	err := s.read(ctx, parent, kind, visit)
+	if visitErr != nil {
+		return visitErr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return s.fail(parent.Path, fmt.Errorf("%s: %w", parent.Path, err))
+	}
+	return nil
+}
+
+func (s *archiveScan) read(ctx context.Context, parent ArchiveEntry, kind string, visit func(ArchiveEntry) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := os.Open(parent.loc.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sr := io.NewSectionReader(f, parent.loc.off, parent.loc.size)
+	if kind == "zip" {
+		return walkZip(sr, parent.loc, visit)
+	}
+
+	head := make([]byte, 4)
+	nh, _ := io.ReadFull(sr, head)
+	if sniffCompression(head[:nh]) != "" {
+		r, err := decompress(io.NewSectionReader(f, parent.loc.off, parent.loc.size))
+		if err != nil {
+			return err
+		}
+		loc, err := s.spool(r)
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return err
+		}
+		parent.loc = loc
+		return s.read(ctx, parent, kind, visit)
+	}
+	if _, err := sr.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return walkTar(sr, parent.loc, visit)
+}
+
+// visit emits e, an entry of parent, or walks it if it is a nested archive
+// within MaxDepth.
+func (s *archiveScan) visit(ctx context.Context, parent, e ArchiveEntry) error {
+	e.Path = parent.Path + ArchiveSep + e.Name
+	e.Archive = parent.Archive
+	e.Depth = parent.Depth + 1
+	e.SpoolDir = s.tempDir
+	if s.only != "" && e.Path != s.only && !strings.HasPrefix(s.only, e.Path+ArchiveSep) {
+		return nil
+	}
+	kind := archiveKind(e.Name)
+	if kind == "" || e.Depth >= s.maxDepth || e.Path == s.only {
+		return s.emit(e)
+	}
+	if e.loc.method != zip.Store {
+		// Nested archives need random access, so inflate them first.
+		rc, err := openLoc(e.loc)
+		if err != nil {
+			return s.fail(e.Path, err)
+		}
+		e.loc, err = s.spool(rc)
+		rc.Close()
+		if err != nil {
+			return s.fail(e.Path, fmt.Errorf("%s: %w", e.Path, err))
+		}
+	}
+	return s.walk(ctx, e, kind)
+}
+
+func walkZip(sr *io.SectionReader, loc entryLoc, visit func(ArchiveEntry) error) error {
+	zr, err := zip.NewReader(sr, sr.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		if zf.Flags&0x1 != 0 {
+			return fmt.Errorf("%s: encrypted entries are not supported", zf.Name)
+		}
+		if zf.Method != zip.Store && zf.Method != zip.Deflate {
+			return fmt.Errorf("%s: unsupported compression method %d", zf.Name, zf.Method)
+		}
+		off, err := zf.DataOffset()
+		if err != nil {
+			return err
+		}
+		err = visit(ArchiveEntry{
+			Name:    entryName(zf.Name),
+			Size:    int64(zf.UncompressedSize64),
+			Mode:    zf.Mode(),
+			ModTime: zf.Modified,
+			loc:     entryLoc{file: loc.file, off: loc.off + off, size: int64(zf.CompressedSize64), method: zf.Method},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTar relies on tar.Reader reading exactly the header blocks, so that
+// after Next the section is positioned at the entry's data.
+func walkTar(sr *io.SectionReader, loc entryLoc, visit func(ArchiveEntry) error) error {
+	tr := tar.NewReader(sr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if isSparse(hdr) {
+			return fmt.Errorf("%s: sparse entries are not supported", hdr.Name)
+		}
+		pos, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		err = visit(ArchiveEntry{
+			Name:    entryName(hdr.Name),
+			Size:    hdr.Size,
+			Mode:    hdr.FileInfo().Mode(),
+			ModTime: hdr.ModTime,
+			loc:     entryLoc{file: loc.file, off: loc.off + pos, size: hdr.Size},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// entryName cleans an archive member name into the form used in virtual
+// paths: slash-separated, without a leading / or ./.
+func entryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// openLoc opens the content at loc.
+func openLoc(loc entryLoc) (io.ReadCloser, error) {
+	f, err := os.Open(loc.file)
+	if err != nil {
+		return nil, err
+	}
+	sr := io.NewSectionReader(f, loc.off, loc.size)
+	if loc.method == zip.Deflate {
+		return readCloser{flate.NewReader(sr), f}, nil
+	}
+	return readCloser{sr, f}, nil
+}
+
+// readCloser reads r and on Close closes r, if it is a Closer, and c.
+type readCloser struct {
+	r io.Reader
+	c io.Closer
+}
+
+func (rc readCloser) Read(p []byte) (int, error) { return rc.r.Read(p) }
+
+func (rc readCloser) Close() error {
+	if c, ok := rc.r.(io.Closer); ok {
+		c.Close()
+	}
+	return rc.c.Close()
+}
+
+// openItem opens the content of an item from a paths port: a file, or an
+// entry inside an archive. A string that names no file but contains
+// ArchiveSep is taken as a virtual path.
+func openItem(v any) (io.ReadCloser, error) {
+	if e, ok := v.(ArchiveEntry); ok {
+		if e.loc.file != "" {
+			return openLoc(e.loc)
+		}
+		return openVirtual(e.Path, e.SpoolDir)
+	}
+	p, _ := itemPath(v)
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) && strings.Contains(p, ArchiveSep) {
+		if rc, verr := openVirtual(p, ""); verr == nil || !errors.Is(verr, fs.ErrNotExist) {
+			return rc, verr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+var errFound = errors.New("found")
+
+// openVirtual opens an entry by virtual path, walking the archives on its
+// way. Spool files it needs are created in tempDir and removed on Close.
+func openVirtual(vpath, tempDir string) (io.ReadCloser, error) {
+	file, _, _ := strings.Cut(vpath, ArchiveSep)
+	kind := archiveKind(file)
+	if kind == "" {
+		return nil, &fs.PathError{Op: "open", Path: vpath, Err: fs.ErrNotExist}
+	}
+	var (
+		spools []string
+		found  ArchiveEntry
+	)
+	cleanup := func() {
+		for _, name := range spools {
+			_ = os.Remove(name)
+		}
+	}
+	s := &archiveScan{
+		maxDepth: strings.Count(vpath, ArchiveSep) + 1,
+		tempDir:  tempDir,
+		only:     vpath,
+		spool: func(r io.Reader) (entryLoc, error) {
+			f, err := os.CreateTemp(tempDir, "gopipes-archive-")
+			if err != nil {
+				return entryLoc{}, err
+			}
+			spools = append(spools, f.Name())
+			size, err := io.Copy(f, r)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			return entryLoc{file: f.Name(), size: size}, err
+		},
+		emit: func(e ArchiveEntry) error {
+			found = e
+			return errFound
+		},
+		fail: func(path string, err error) error { return WalkError{Path: path, Err: err} },
+	}
+	err := s.archive(context.Background(), file, kind)
+	if !errors.Is(err, errFound) {
+		cleanup()
+		if err == nil {
+			err = &fs.PathError{Op: "open", Path: vpath, Err: fs.ErrNotExist}
+		}
+		return nil, err
+	}
+	rc, err := openLoc(found.loc)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return readCloser{rc, closerFunc(func() error { cleanup(); return nil })}, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
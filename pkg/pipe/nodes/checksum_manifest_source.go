@@ -0,0 +1,226 @@
+package nodes
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"go-pipes/pkg/pipe"
+)
+
+// ChecksumEntry is one line of a checksum manifest.
+// Name is the file name as listed; Path is Name resolved against the
+// source's base directory.
+type ChecksumEntry struct {
+	Name      string
+	Path      string
+	Algorithm string
+	Sum       []byte
+	Binary    bool
+	Line      int
+}
+
+// bsdTags maps the tags used by `md5sum --tag` and friends to algorithm names.
+var bsdTags = map[string]string{
+	"MD5":     "md5",
+	"SHA1":    "sha1",
+	"SHA256":  "sha256",
+	"SHA512":  "sha512",
+	"BLAKE2b": "blake2b",
+	"CRC32":   "crc32",
+	"ADLER32": "adler32",
+}
+
+// algorithmsBySize guesses the algorithm of an untagged GNU line from its
+// digest length, as coreutils does per tool. 64 bytes is left out: sha512sum
+// and b2sum both write such digests, so those lines need Algorithm.
+var algorithmsBySize = map[int]string{
+	16: "md5",
+	20: "sha1",
+	32: "sha256",
+}
+
+// ChecksumManifestSource reads GNU coreutils checksum files (as written by
+// md5sum, sha256sum, b2sum, with or without --tag) and emits one entry per
+// listed file, plus the file path for hashing. Untagged sha512sum and b2sum
+// lines look the same, so reading them requires Algorithm.
+type ChecksumManifestSource struct {
+	pipe.BaseNode
+	Paths []string
+	// Algorithm forces the algorithm of untagged lines; empty guesses it
+	// from the digest length.
+	Algorithm string
+	// Base resolves relative names; empty keeps them relative to the
+	// working directory like md5sum -c.
+	Base string
+	// Warnings receives a note per manifest with improperly formatted
+	// lines, as md5sum -c prints; nil discards them.
+	Warnings io.Writer
+}
+
+var checksumManifestSourcePorts = []pipe.PortSpec{
+	{Name: "entries", Dir: pipe.PortOut, Required: true, Type: reflect.TypeFor[ChecksumEntry](), Doc: "expected checksums"},
+	{Name: "paths", Dir: pipe.PortOut, Type: reflect.TypeFor[string](), Doc: "listed file paths, for a hasher"},
+}
+
+func NewChecksumManifestSource(id string, paths ...string) *ChecksumManifestSource {
+	return &ChecksumManifestSource{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: checksumManifestSourcePorts}, Paths: paths}
+}
+
+func (n *ChecksumManifestSource) Start(ctx context.Context) error {
+	defer n.CloseOutputs()
+	entries, _ := n.GetOutput("entries")
+	paths, _ := n.GetOutput("paths")
+	if entries == nil {
+		return nil
+	}
+	for _, p := range n.Paths {
+		if err := n.readManifest(ctx, p, entries, paths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *ChecksumManifestSource) readManifest(ctx context.Context, path string, entries, paths chan any) error {
+	r, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var valid, malformed int
+	var firstErr error
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimRight(sc.Text(), "\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		e, err := parseChecksumLine(text, n.Algorithm)
+		if err != nil {
+			if malformed == 0 {
+				firstErr = fmt.Errorf("line %d: %w", line, err)
+			}
+			malformed++
+			continue
+		}
+		valid++
+		e.Line = line
+		e.Path = e.Name
+		if n.Base != "" && !filepath.IsAbs(e.Name) {
+			e.Path = filepath.Join(n.Base, e.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entries <- e:
+		}
+		if paths != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case paths <- e.Path:
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if valid == 0 {
+		if firstErr != nil {
+			return fmt.Errorf("%s: no properly formatted checksum lines found (%w)", path, firstErr)
+		}
+		return fmt.Errorf("%s: no properly formatted checksum lines found", path)
+	}
+	if malformed > 0 && n.Warnings != nil {
+		fmt.Fprintf(n.Warnings, "%s: WARNING: %d line(s) improperly formatted\n", path, malformed)
+	}
+	return nil
+}
+
+// parseChecksumLine parses one manifest line in either format:
+//
+//	d41d8cd98f00b204e9800998ecf8427e  name     (GNU, text mode)
+//	d41d8cd98f00b204e9800998ecf8427e *name     (GNU, binary mode)
+//	MD5 (name) = d41d8cd98f00b204e9800998ecf8427e  (BSD, --tag)
+//
+// A leading backslash means the name contains \\ or \n escapes.
+func parseChecksumLine(line, algorithm string) (ChecksumEntry, error) {
+	var e ChecksumEntry
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+
+	if tag, rest, ok := strings.Cut(line, " ("); ok {
+		if algo, known := bsdTags[tag]; known {
+			idx := strings.LastIndex(rest, ") = ")
+			if idx < 0 {
+				return e, fmt.Errorf("malformed tagged line")
+			}
+			sum, err := hex.DecodeString(rest[idx+4:])
+			if err != nil {
+				return e, err
+			}
+			e.Name, e.Algorithm, e.Sum = rest[:idx], algo, sum
+			return e, unescapeName(&e, escaped)
+		}
+	}
+
+	digest, rest, ok := strings.Cut(line, " ")
+	if !ok || len(rest) < 2 || (rest[0] != ' ' && rest[0] != '*') {
+		return e, fmt.Errorf("malformed line")
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return e, err
+	}
+	e.Sum, e.Binary, e.Name = sum, rest[0] == '*', rest[1:]
+	e.Algorithm = algorithm
+	if e.Algorithm == "" {
+		if e.Algorithm = algorithmsBySize[len(sum)]; e.Algorithm == "" {
+			if len(sum) == 64 {
+				return e, fmt.Errorf("a 64-byte digest may be sha512 or blake2b, set algorithm")
+			}
+			return e, fmt.Errorf("cannot guess algorithm of a %d-byte digest", len(sum))
+		}
+	}
+	return e, unescapeName(&e, escaped)
+}
+
+func unescapeName(e *ChecksumEntry, escaped bool) error {
+	if !escaped {
+		return nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(e.Name); i++ {
+		c := e.Name[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(e.Name) {
+			return fmt.Errorf("dangling escape in name")
+		}
+		i++
+		switch e.Name[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return fmt.Errorf("unknown escape \\%c in name", e.Name[i])
+		}
+	}
+	e.Name = b.String()
+	return nil
+}
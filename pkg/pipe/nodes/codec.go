@@ -0,0 +1,154 @@
+package nodes
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Compression codecs for file outputs, see CompressionFor. Inputs are
+// detected from their content instead, see openInput; bzip2 can only be
+// read, as the standard library has no bzip2 writer. zstd is not supported
+// in either direction for the same reason.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZlib = "zlib"
+)
+
+// Compressions returns the codec names accepted for outputs.
+func Compressions() []string {
+	return []string{CompressNone, CompressGzip, CompressZlib}
+}
+
+// CompressionFor returns the codec to write path with: codec itself if set,
+// otherwise gzip for a .gz path, zlib for .zz and none for anything else.
+func CompressionFor(path, codec string) (string, error) {
+	switch codec {
+	case CompressNone, CompressGzip, CompressZlib:
+		return codec, nil
+	case "":
+		switch {
+		case strings.HasSuffix(path, ".gz"):
+			return CompressGzip, nil
+		case strings.HasSuffix(path, ".zz"):
+			return CompressZlib, nil
+		}
+		return CompressNone, nil
+	}
+	return "", fmt.Errorf("unknown compression %q (supported: %s)", codec, strings.Join(Compressions(), ", "))
+}
+
+// newCompressor returns a writer compressing into w. Close ends the stream
+// but leaves w open.
+func newCompressor(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case "", CompressNone:
+		return nopWriteCloser{w}, nil
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZlib:
+		return zlib.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", codec)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+var errZstd = errors.New("zstd input is not supported, decompress it first")
+
+// sniffCompression names the codec whose header starts head: gzip, bzip2,
+// zlib or zstd, or "" for uncompressed content.
+func sniffCompression(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return CompressGzip
+	case bytes.HasPrefix(head, []byte("BZh")) && len(head) >= 4 && head[3] >= '1' && head[3] <= '9':
+		return "bzip2"
+	case isZlibHeader(head):
+		return CompressZlib
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "zstd"
+	}
+	return ""
+}
+
+// decompress returns the content of r, decompressed when it starts with a
+// gzip, bzip2 or zlib header. Concatenated gzip members, as written by
+// appending to a .gz file, are read as one stream.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4)
+	switch sniffCompression(head) {
+	case CompressGzip:
+		return gzip.NewReader(br)
+	case "bzip2":
+		return bzip2.NewReader(br), nil
+	case CompressZlib:
+		return zlib.NewReader(br)
+	case "zstd":
+		return nil, errZstd
+	}
+	return br, nil
+}
+
+// isZlibHeader recognizes the deflate headers zlib writers emit for the
+// usual compression levels; text rarely starts with these two bytes.
+func isZlibHeader(head []byte) bool {
+	if len(head) < 2 || head[0] != 0x78 {
+		return false
+	}
+	switch head[1] {
+	case 0x01, 0x5e, 0x9c, 0xda:
+		return true
+	}
+	return false
+}
+
+// openInput opens a file-based input, "-" meaning stdin, decompressing it
+// transparently.
+func openInput(path string) (io.ReadCloser, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	r, err := decompress(f)
+	if err != nil {
+		if f != os.Stdin {
+			f.Close()
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return inputFile{r, f}, nil
+}
+
+// inputFile reads decompressed content and closes the underlying file,
+// unless it is stdin.
+type inputFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (i inputFile) Close() error {
+	if c, ok := i.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	if i.f == os.Stdin {
+		return nil
+	}
+	return i.f.Close()
+}
@@ -0,0 +1,385 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-pipes/pkg/pipe"
+)
+
+// Dedup actions applied to every duplicate group.
+const (
+	DedupReport       = "report"
+	DedupHardlink     = "hardlink"
+	DedupDeleteButOne = "delete-but-one"
+)
+
+// DuplicateGroup lists files with identical content. Paths[0] is the copy
+// that is kept by the hardlink and delete-but-one actions.
+type DuplicateGroup struct {
+	Size      int64
+	Algorithm string
+	Digest    []byte
+	Paths     []string
+	Wasted    int64 // Size times the number of redundant copies
+	Actions   []string
+	Err       error
+}
+
+func (g DuplicateGroup) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%x  %d copies of %d bytes, %d wasted", g.Digest, len(g.Paths), g.Size, g.Wasted)
+	for _, p := range g.Paths {
+		fmt.Fprintf(&b, "\n  %s", p)
+	}
+	for _, a := range g.Actions {
+		fmt.Fprintf(&b, "\n  # %s", a)
+	}
+	if g.Err != nil {
+		fmt.Fprintf(&b, "\n  # error: %v", g.Err)
+	}
+	return b.String()
+}
+
+// Dedup finds duplicate files among the input paths. It groups files by
+// size first, then by a hash of the first and last PartialSize bytes, and
+// only fully hashes files that still collide, so unique files are never
+// read completely. Groups are emitted once the input closes.
+type Dedup struct {
+	pipe.BaseNode
+	Workers     int
+	PartialSize int64  // bytes hashed from each end in the second pass
+	MinSize     int64  // smaller files are ignored
+	Algorithm   string // digest used for partial and full hashes
+	Action      string
+	DryRun      bool
+}
+
+var dedupPorts = []pipe.PortSpec{
+	{Name: "paths", Dir: pipe.PortIn, Required: true, Doc: "files to compare: string paths or FileInfo"},
+	{Name: "groups", Dir: pipe.PortOut, Required: true, Type: reflect.TypeFor[DuplicateGroup](), Doc: "one group per set of identical files"},
+}
+
+func NewDedup(id string, workers int) *Dedup {
+	if workers <= 0 {
+		workers = 10
+	}
+	return &Dedup{
+		BaseNode:    pipe.BaseNode{IDValue: id, PortSpecs: dedupPorts},
+		Workers:     workers,
+		PartialSize: 4096,
+		MinSize:     1,
+		Algorithm:   "sha256",
+		Action:      DedupReport,
+		DryRun:      true,
+	}
+}
+
+type dedupFile struct {
+	path string
+	info os.FileInfo
+}
+
+// dedupGroup is a set of files sharing the digest they were grouped by.
+type dedupGroup struct {
+	files  []dedupFile
+	digest []byte
+}
+
+func (n *Dedup) Start(ctx context.Context) error {
+	defer n.CloseOutputs()
+	in, _ := n.GetInput("paths")
+	out, _ := n.GetOutput("groups")
+	if in == nil || out == nil {
+		return nil
+	}
+	if _, ok := hashAlgorithms[n.Algorithm]; !ok {
+		return fmt.Errorf("unknown hash algorithm %q", n.Algorithm)
+	}
+	switch n.Action {
+	case "", DedupReport, DedupHardlink, DedupDeleteButOne:
+	default:
+		return fmt.Errorf("unknown dedup action %q", n.Action)
+	}
+
+	// Pass 1: group by size.
+	bySize := make(map[int64][]dedupFile)
+	for {
+		var v any
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case v, ok = <-in:
+		}
+		if !ok {
+			break
+		}
+		path, _ := itemPath(v)
+		fi, err := os.Stat(path)
+		if err != nil {
+			if err := n.reject(ctx, path, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() || fi.Size() < n.MinSize {
+			continue
+		}
+		bySize[fi.Size()] = appendDistinct(bySize[fi.Size()], dedupFile{path: path, info: fi})
+	}
+
+	var candidates []dedupGroup
+	for _, files := range bySize {
+		if len(files) > 1 {
+			candidates = append(candidates, dedupGroup{files: files})
+		}
+	}
+
+	// Pass 2: partial hash of head and tail. Files no larger than both ends
+	// together are hashed completely here and skip pass 3.
+	partial, err := n.regroup(ctx, candidates, func(f dedupFile) ([]byte, error) {
+		return n.partialHash(f)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Pass 3: full hash of the files that still collide. Either way the
+	// digest of a final group is that of the whole content.
+	var final, needFull []dedupGroup
+	for _, g := range partial {
+		if g.files[0].info.Size() <= 2*n.PartialSize {
+			final = append(final, g)
+		} else {
+			needFull = append(needFull, g)
+		}
+	}
+	full, err := n.regroup(ctx, needFull, func(f dedupFile) ([]byte, error) {
+		_, sums, err := hashFile(f.path, []string{n.Algorithm})
+		return sums[n.Algorithm], err
+	})
+	if err != nil {
+		return err
+	}
+	final = append(final, full...)
+
+	groups := make([]DuplicateGroup, 0, len(final))
+	for _, fg := range final {
+		g := DuplicateGroup{Size: fg.files[0].info.Size(), Algorithm: n.Algorithm, Digest: fg.digest}
+		for _, f := range fg.files {
+			g.Paths = append(g.Paths, f.path)
+		}
+		sort.Strings(g.Paths)
+		g.Wasted = g.Size * int64(len(g.Paths)-1)
+		groups = append(groups, g)
+	}
+	// Largest waste first, then by path so runs are reproducible.
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Wasted != groups[j].Wasted {
+			return groups[i].Wasted > groups[j].Wasted
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+
+	for _, g := range groups {
+		n.apply(&g)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- g:
+		}
+	}
+	return nil
+}
+
+// appendDistinct skips paths that are hard links to a file already in
+// files: they share storage, so they are not wasted copies.
+func appendDistinct(files []dedupFile, f dedupFile) []dedupFile {
+	for _, o := range files {
+		if os.SameFile(o.info, f.info) {
+			return files
+		}
+	}
+	return append(files, f)
+}
+
+func (n *Dedup) reject(ctx context.Context, path string, err error) error {
+	if n.OnError.Mode == "" {
+		return nil
+	}
+	return n.Reject(ctx, path, 1, err)
+}
+
+func (n *Dedup) partialHash(f dedupFile) ([]byte, error) {
+	if f.info.Size() <= 2*n.PartialSize {
+		_, sums, err := hashFile(f.path, []string{n.Algorithm})
+		return sums[n.Algorithm], err
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	head := io.NewSectionReader(file, 0, n.PartialSize)
+	tail := io.NewSectionReader(file, f.info.Size()-n.PartialSize, n.PartialSize)
+	_, sums, err := hashReader(io.MultiReader(head, tail), []string{n.Algorithm})
+	return sums[n.Algorithm], err
+}
+
+// regroup splits every group by the digest key computes for its files, in
+// parallel, and returns the subgroups that still have more than one file.
+func (n *Dedup) regroup(ctx context.Context, groups []dedupGroup, key func(dedupFile) ([]byte, error)) ([]dedupGroup, error) {
+	type job struct {
+		group int
+		file  dedupFile
+	}
+	type keyed struct {
+		file dedupFile
+		key  []byte
+	}
+	jobs := make(chan job)
+	var (
+		mu    sync.Mutex
+		keys  = make([][]keyed, len(groups))
+		wg    sync.WaitGroup
+		fail  error
+		fails sync.Once
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wg.Add(n.Workers)
+	for i := 0; i < n.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				k, err := key(j.file)
+				if err != nil {
+					if err := n.reject(ctx, j.file.path, err); err != nil {
+						fails.Do(func() { fail = err })
+						cancel()
+					}
+					continue
+				}
+				mu.Lock()
+				keys[j.group] = append(keys[j.group], keyed{file: j.file, key: k})
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for gi, g := range groups {
+		for _, f := range g.files {
+			select {
+			case <-ctx.Done():
+				break feed
+			case jobs <- job{group: gi, file: f}:
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if fail != nil {
+		return nil, fail
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []dedupGroup
+	for _, ks := range keys {
+		sort.Slice(ks, func(i, j int) bool { return bytes.Compare(ks[i].key, ks[j].key) < 0 })
+		for i := 0; i < len(ks); {
+			j := i + 1
+			for j < len(ks) && bytes.Equal(ks[i].key, ks[j].key) {
+				j++
+			}
+			if j-i > 1 {
+				sub := make([]dedupFile, 0, j-i)
+				for _, k := range ks[i:j] {
+					sub = append(sub, k.file)
+				}
+				out = append(out, dedupGroup{files: sub, digest: ks[i].key})
+			}
+			i = j
+		}
+	}
+	return out, nil
+}
+
+// apply runs the configured action on a group, or describes it in dry-run
+// mode. Paths[0] is always kept. Files are checked against the group's size
+// and digest right before they are touched, so one that changed since it
+// was hashed stops the action instead of losing data.
+func (n *Dedup) apply(g *DuplicateGroup) {
+	keep := g.Paths[0]
+	modifies := !n.DryRun && (n.Action == DedupHardlink || n.Action == DedupDeleteButOne)
+	if modifies {
+		if g.Err = n.unchanged(g, keep); g.Err != nil {
+			return
+		}
+	}
+	for _, dup := range g.Paths[1:] {
+		if modifies {
+			if g.Err = n.unchanged(g, dup); g.Err != nil {
+				return
+			}
+		}
+		switch n.Action {
+		case DedupHardlink:
+			if n.DryRun {
+				g.Actions = append(g.Actions, fmt.Sprintf("would hardlink %s -> %s", dup, keep))
+				continue
+			}
+			// Link under a temporary name and rename over the duplicate so
+			// the path never disappears.
+			tmp := dup + ".dedup-tmp"
+			if err := os.Link(keep, tmp); err != nil {
+				g.Err = err
+				return
+			}
+			if err := os.Rename(tmp, dup); err != nil {
+				_ = os.Remove(tmp)
+				g.Err = err
+				return
+			}
+			g.Actions = append(g.Actions, fmt.Sprintf("hardlinked %s -> %s", dup, keep))
+		case DedupDeleteButOne:
+			if n.DryRun {
+				g.Actions = append(g.Actions, fmt.Sprintf("would delete %s", dup))
+				continue
+			}
+			if err := os.Remove(dup); err != nil {
+				g.Err = err
+				return
+			}
+			g.Actions = append(g.Actions, fmt.Sprintf("deleted %s", dup))
+		}
+	}
+}
+
+// unchanged re-checks that path still has the size and digest of group g.
+func (n *Dedup) unchanged(g *DuplicateGroup, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() || fi.Size() != g.Size {
+		return fmt.Errorf("%s changed since it was hashed", path)
+	}
+	_, sums, err := hashFile(path, []string{n.Algorithm})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sums[n.Algorithm], g.Digest) {
+		return fmt.Errorf("%s changed since it was hashed", path)
+	}
+	return nil
+}
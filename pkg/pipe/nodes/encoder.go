@@ -0,0 +1,343 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-pipes/pkg/pipe"
+)
+
+// Output formats accepted by NewEncoder.
+const (
+	FormatText      = "text"      // the item's String form; failed results as ERROR: lines
+	FormatCoreutils = "coreutils" // md5sum/sha256sum lines, readable by checksum_manifest_source
+	FormatJSONL     = "jsonl"     // one JSON object per line
+	FormatCSV       = "csv"       // RFC 4180 with a header row
+	FormatTSV       = "tsv"       // tab-separated with a header row, \t \n \r \\ escaped
+)
+
+// Formats returns the names accepted by NewEncoder.
+func Formats() []string {
+	return []string{FormatText, FormatCoreutils, FormatJSONL, FormatCSV, FormatTSV}
+}
+
+// Encoder turns items into lines of one output format. Encoders are
+// stateless, so several workers may format items concurrently and leave the
+// writing to one recordWriter.
+type Encoder interface {
+	// Header returns what is written before the first item, which is v, or
+	// nil when the format has no header.
+	Header(v any) []byte
+	// Append appends the encoding of v, including its line terminator, to
+	// buf. An error means v was not written, e.g. a failed result in the
+	// coreutils format, which has no way to express it.
+	Append(buf []byte, v any) ([]byte, error)
+}
+
+func NewEncoder(format string) (Encoder, error) {
+	switch format {
+	case "", FormatText:
+		return textEncoder{}, nil
+	case FormatCoreutils:
+		return coreutilsEncoder{}, nil
+	case FormatJSONL:
+		return jsonlEncoder{}, nil
+	case FormatCSV:
+		return separatedEncoder{comma: ','}, nil
+	case FormatTSV:
+		return separatedEncoder{comma: '\t'}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q (supported: %s)", format, strings.Join(Formats(), ", "))
+}
+
+// field is one named value of a record. Values are string, int64, uint64,
+// int, bool, []string or nil.
+type field struct {
+	name  string
+	value any
+}
+
+func errValue(err error) any {
+	if err == nil {
+		return nil
+	}
+	return err.Error()
+}
+
+// record flattens an item into named fields with a fixed order per type.
+func record(v any) []field {
+	switch r := v.(type) {
+	case string:
+		return []field{{"path", r}}
+	case FileInfo:
+		return []field{
+			{"path", r.Path}, {"root", r.Root}, {"rel", r.Rel}, {"size", r.Size},
+			{"mode", r.Mode.String()}, {"mtime", r.ModTime.Format(time.RFC3339Nano)},
+			{"device", r.Device}, {"inode", r.Inode}, {"via_symlink", r.ViaSymlink},
+		}
+	case MD5Result:
+		var sum any
+		if r.Err == nil {
+			sum = hex.EncodeToString(r.Sum[:])
+		}
+		return []field{{"path", r.Path}, {"size", r.Size}, {"md5", sum}, {"error", errValue(r.Err)}}
+	case HashResult:
+		fs := []field{{"path", r.Path}, {"size", r.Size}}
+		algos := make([]string, 0, len(r.Sums))
+		for a := range r.Sums {
+			algos = append(algos, a)
+		}
+		sort.Strings(algos)
+		for _, a := range algos {
+			var sum any
+			if r.Sums[a] != nil {
+				sum = r.Hex(a)
+			}
+			fs = append(fs, field{a, sum})
+		}
+		return append(fs, field{"error", errValue(r.Err)})
+	case VerifyResult:
+		return []field{
+			{"name", r.Name}, {"path", r.Path}, {"algorithm", r.Algorithm}, {"status", r.Status},
+			{"expected", hex.EncodeToString(r.Expected)}, {"actual", hex.EncodeToString(r.Actual)},
+			{"error", errValue(r.Err)},
+		}
+	case VerifySummary:
+		return []field{{"total", r.Total}, {"ok", r.OK}, {"failed", r.Failed}, {"missing", r.Missing}}
+	case ChecksumEntry:
+		return []field{
+			{"name", r.Name}, {"path", r.Path}, {"algorithm", r.Algorithm},
+			{"sum", hex.EncodeToString(r.Sum)}, {"binary", r.Binary}, {"line", r.Line},
+		}
+	case DuplicateGroup:
+		return []field{
+			{"size", r.Size}, {"algorithm", r.Algorithm}, {"digest", hex.EncodeToString(r.Digest)},
+			{"paths", r.Paths}, {"wasted", r.Wasted}, {"actions", r.Actions}, {"error", errValue(r.Err)},
+		}
+	case WalkError:
+		return []field{{"path", r.Path}, {"error", errValue(r.Err)}}
+	case ArchiveEntry:
+		return []field{
+			{"path", r.Path}, {"archive", r.Archive}, {"name", r.Name}, {"size", r.Size},
+			{"mode", r.Mode.String()}, {"mtime", r.ModTime.Format(time.RFC3339Nano)}, {"depth", r.Depth},
+		}
+	case pipe.DeadLetter:
+		return []field{{"node", r.Node}, {"item", fmt.Sprint(r.Item)}, {"error", errValue(r.Err)}, {"attempts", r.Attempts}}
+	}
+	return []field{{"value", fmt.Sprint(v)}}
+}
+
+// failure returns the path and error of a failed result.
+func failure(v any) (string, error) {
+	switch r := v.(type) {
+	case MD5Result:
+		return r.Path, r.Err
+	case HashResult:
+		return r.Path, r.Err
+	}
+	return "", nil
+}
+
+type textEncoder struct{}
+
+func (textEncoder) Header(any) []byte { return nil }
+
+func (textEncoder) Append(buf []byte, v any) ([]byte, error) {
+	if path, err := failure(v); err != nil {
+		return fmt.Appendf(buf, "ERROR: %s: %v\n", path, err), nil
+	}
+	return fmt.Appendf(buf, "%v\n", v), nil
+}
+
+type coreutilsEncoder struct{}
+
+func (coreutilsEncoder) Header(any) []byte { return nil }
+
+// Append writes GNU lines for a single digest whose algorithm a reader can
+// tell from its length, and BSD tagged lines otherwise, one per algorithm
+// when a HashResult carries several. Names with a backslash or newline are
+// escaped the way coreutils does.
+func (coreutilsEncoder) Append(buf []byte, v any) ([]byte, error) {
+	if path, err := failure(v); err != nil {
+		return buf, fmt.Errorf("%s: %w", path, err)
+	}
+	switch r := v.(type) {
+	case MD5Result:
+		return appendChecksumLine(buf, "", r.Sum[:], r.Path), nil
+	case HashResult:
+		if len(r.Sums) == 1 {
+			for a, sum := range r.Sums {
+				return appendChecksumLine(buf, lineTag(a, sum), sum, r.Path), nil
+			}
+		}
+		algos := make([]string, 0, len(r.Sums))
+		for a := range r.Sums {
+			algos = append(algos, a)
+		}
+		sort.Strings(algos)
+		for _, a := range algos {
+			buf = appendChecksumLine(buf, a, r.Sums[a], r.Path)
+		}
+		return buf, nil
+	case ChecksumEntry:
+		return appendChecksumLine(buf, lineTag(r.Algorithm, r.Sum), r.Sum, r.Name), nil
+	}
+	return fmt.Appendf(buf, "%v\n", v), nil
+}
+
+// lineTag returns "" when algo is what algorithmsBySize guesses for sum,
+// so an untagged line reads back the same, and algo otherwise.
+func lineTag(algo string, sum []byte) string {
+	if algorithmsBySize[len(sum)] == algo {
+		return ""
+	}
+	return algo
+}
+
+func appendChecksumLine(buf []byte, algo string, sum []byte, name string) []byte {
+	if strings.ContainsAny(name, "\\\n\r") {
+		buf = append(buf, '\\')
+		name = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(name)
+	}
+	if algo == "" {
+		return fmt.Appendf(buf, "%x  %s\n", sum, name)
+	}
+	tag := strings.ToUpper(algo)
+	for t, a := range bsdTags {
+		if a == algo {
+			tag = t
+		}
+	}
+	return fmt.Appendf(buf, "%s (%s) = %x\n", tag, name, sum)
+}
+
+type jsonlEncoder struct{}
+
+func (jsonlEncoder) Header(any) []byte { return nil }
+
+func (jsonlEncoder) Append(buf []byte, v any) ([]byte, error) {
+	buf = append(buf, '{')
+	for i, f := range record(v) {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(f.name)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return buf, err
+		}
+		buf = append(buf, name...)
+		buf = append(buf, ':')
+		buf = append(buf, value...)
+	}
+	return append(buf, '}', '\n'), nil
+}
+
+// separatedEncoder writes CSV or TSV. The header comes from the first
+// item, so a sink should receive one record type. Lists are joined with
+// newlines within a cell.
+type separatedEncoder struct {
+	comma rune
+}
+
+func (e separatedEncoder) Header(v any) []byte {
+	fs := record(v)
+	names := make([]string, len(fs))
+	for i, f := range fs {
+		names[i] = f.name
+	}
+	return e.line(nil, names)
+}
+
+func (e separatedEncoder) Append(buf []byte, v any) ([]byte, error) {
+	fs := record(v)
+	cells := make([]string, len(fs))
+	for i, f := range fs {
+		switch x := f.value.(type) {
+		case nil:
+		case []string:
+			cells[i] = strings.Join(x, "\n")
+		default:
+			cells[i] = fmt.Sprint(x)
+		}
+	}
+	return e.line(buf, cells), nil
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (e separatedEncoder) line(buf []byte, cells []string) []byte {
+	if e.comma == '\t' {
+		for i, c := range cells {
+			if i > 0 {
+				buf = append(buf, '\t')
+			}
+			buf = append(buf, tsvEscaper.Replace(c)...)
+		}
+		return append(buf, '\n')
+	}
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	_ = w.Write(cells) // writes to memory cannot fail
+	w.Flush()
+	return append(buf, b.Bytes()...)
+}
+
+// recordWriter writes encoded items to w, preceded by the encoder's header.
+// It is safe for concurrent use.
+type recordWriter struct {
+	mu      sync.Mutex
+	enc     Encoder
+	w       io.Writer
+	started bool
+}
+
+func newRecordWriter(enc Encoder, w io.Writer) *recordWriter {
+	return &recordWriter{enc: enc, w: w}
+}
+
+// writeLine writes the already encoded line of v.
+func (rw *recordWriter) writeLine(v any, line []byte) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if !rw.started {
+		rw.started = true
+		if h := rw.enc.Header(v); h != nil {
+			if _, err := rw.w.Write(h); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := rw.w.Write(line)
+	return err
+}
+
+// reportSkipped notes an item the encoder skipped on w, unless w is nil,
+// prefixed with the node id, and returns nil; any other error is returned
+// as is.
+func reportSkipped(w io.Writer, id string, err error) error {
+	var skipped skippedError
+	if errors.As(err, &skipped) {
+		if w != nil {
+			fmt.Fprintf(w, "%s: %v\n", id, skipped.err)
+		}
+		return nil
+	}
+	return err
+}
+
+// skippedError marks an item the encoder could not represent; the output
+// itself is fine.
+type skippedError struct{ err error }
+
+func (e skippedError) Error() string { return e.err.Error() }
+func (e skippedError) Unwrap() error { return e.err }
@@ -0,0 +1,69 @@
+package nodes
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestCoreutilsRoundTrip checks that every line the coreutils encoder
+// writes is read back by parseChecksumLine with the same algorithm, digest
+// and name.
+func TestCoreutilsRoundTrip(t *testing.T) {
+	names := []string{"plain.txt", "dir/with space", "back\\slash", "new\nline"}
+	var results []HashResult
+	for _, name := range names {
+		for _, algo := range HashAlgorithms() {
+			_, sums, err := hashReader(strings.NewReader(name), []string{algo})
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, HashResult{Path: name, Sums: sums})
+		}
+		_, sums, err := hashReader(strings.NewReader(name), HashAlgorithms())
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, HashResult{Path: name, Sums: sums})
+	}
+
+	enc, err := NewEncoder(FormatCoreutils)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		buf, err := enc.Append(nil, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+		if len(lines) != len(r.Sums) {
+			t.Fatalf("%q: %d lines for %d digests:\n%s", r.Path, len(lines), len(r.Sums), buf)
+		}
+		for _, line := range lines {
+			e, err := parseChecksumLine(line, "")
+			if err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			if e.Name != r.Path {
+				t.Errorf("%q: name %q, want %q", line, e.Name, r.Path)
+			}
+			sum, ok := r.Sums[e.Algorithm]
+			if !ok {
+				t.Errorf("%q: read back as %s, not one of the written algorithms", line, e.Algorithm)
+				continue
+			}
+			if !bytes.Equal(e.Sum, sum) {
+				t.Errorf("%q: %s digest %x, want %x", line, e.Algorithm, e.Sum, sum)
+			}
+			// Re-encoding the parsed entry must give the same line.
+			again, err := enc.Append(nil, e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Sums) == 1 && string(again) != line+"\n" {
+				t.Errorf("entry re-encoded as %q, want %q", again, line+"\n")
+			}
+		}
+	}
+}
@@ -3,8 +3,11 @@ package nodes
 import (
     "bufio"
     "context"
-    "fmt"
+    "io"
     "os"
+    "path/filepath"
+    "sync"
+    "time"
 
     "go-pipes/pkg/pipe"
 )
@@ -14,10 +17,29 @@ type FileSink struct {
     Path   string
     Append bool
     Workers int
+    Format  string // see NewEncoder; empty means text
+    // Atomic writes to a temporary file in the same directory and renames
+    // it over Path only once the input has closed without errors and the
+    // run was not interrupted (see pipe.Interrupted), so Path always holds
+    // either the old or the complete new output.
+    Atomic bool
+    // Rotation, when any limit is set, writes a series of segment files
+    // named by Path as a template instead of one file; see Rotation.
+    Rotation Rotation
+    // Compress is the output codec, see CompressionFor; empty picks it
+    // from the extension of Path, so a .gz path is written gzipped.
+    Compress string
+    // Warnings receives a note per item the format cannot represent, such
+    // as a failed result in coreutils format; nil discards them.
+    Warnings io.Writer
+}
+
+var fileSinkPorts = []pipe.PortSpec{
+    {Name: "in", Dir: pipe.PortIn, Required: true, Doc: "items to write, one per line"},
 }
 
 func NewFileSink(id, path string, append bool) *FileSink {
-    return &FileSink{BaseNode: pipe.BaseNode{IDValue: id}, Path: path, Append: append, Workers: 1}
+    return &FileSink{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: fileSinkPorts}, Path: path, Append: append, Workers: 1, Format: FormatText}
 }
 
 func (n *FileSink) Start(ctx context.Context) error {
@@ -29,93 +51,174 @@ func (n *FileSink) Start(ctx context.Context) error {
     if n.Path == "" {
         n.Path = "md5-output.txt"
     }
-    flag := os.O_CREATE | os.O_WRONLY
-    if n.Append {
-        flag |= os.O_APPEND
-    } else {
-        flag |= os.O_TRUNC
+    enc, err := NewEncoder(n.Format)
+    if err != nil {
+        return err
+    }
+    codec, err := CompressionFor(n.Path, n.Compress)
+    if err != nil {
+        return err
+    }
+    if n.Rotation.enabled() {
+        return n.writeRotating(ctx, in, enc, codec)
     }
-    f, err := os.OpenFile(n.Path, flag, 0644)
+    f, err := n.open()
     if err != nil {
         return err
     }
-    defer f.Close()
     w := bufio.NewWriter(f)
-    defer w.Flush()
+    z, err := newCompressor(w, codec)
+    if err != nil {
+        return n.finish(ctx, f, err)
+    }
+    err = n.write(ctx, in, enc, newRecordWriter(enc, z).writeLine, nil, nil)
+    if zerr := z.Close(); err == nil {
+        err = zerr
+    }
+    if ferr := w.Flush(); err == nil {
+        err = ferr
+    }
+    return n.finish(ctx, f, err)
+}
 
-    workers := n.Workers
-    if workers <= 1 {
-        // single-threaded streaming write
-        for {
-            select {
-            case <-ctx.Done():
-                return ctx.Err()
-            case v, ok := <-in:
-                if !ok {
-                    return nil
-                }
-                switch r := v.(type) {
-                case MD5Result:
-                    if r.Err != nil {
-                        fmt.Fprintf(w, "ERROR: %s: %v\n", r.Path, r.Err)
-                    } else {
-                        fmt.Fprintf(w, "%s\n", r.String())
-                    }
-                default:
-                    fmt.Fprintf(w, "%v\n", r)
-                }
-            }
+// open creates the output file, or with Atomic a temporary file next to it.
+func (n *FileSink) open() (*os.File, error) {
+    if !n.Atomic {
+        flag := os.O_CREATE | os.O_WRONLY
+        if n.Append {
+            flag |= os.O_APPEND
+        } else {
+            flag |= os.O_TRUNC
+        }
+        return os.OpenFile(n.Path, flag, 0644)
+    }
+    dir, base := filepath.Dir(n.Path), filepath.Base(n.Path)
+    f, err := os.CreateTemp(dir, "."+base+".tmp-*")
+    if err != nil {
+        return nil, err
+    }
+    mode := os.FileMode(0644)
+    if fi, err := os.Stat(n.Path); err == nil {
+        mode = fi.Mode().Perm()
+    }
+    if err := f.Chmod(mode); err != nil {
+        f.Close()
+        os.Remove(f.Name())
+        return nil, err
+    }
+    return f, nil
+}
+
+// finish closes f. With Atomic, a clean run syncs the temporary file and
+// renames it over the target; a failed, cancelled or draining one removes
+// it and leaves the target untouched.
+func (n *FileSink) finish(ctx context.Context, f *os.File, err error) error {
+    if !n.Atomic {
+        if cerr := f.Close(); err == nil {
+            err = cerr
         }
+        return err
+    }
+    if err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
     }
+    // An upstream node that fails closes its outputs just before the run is
+    // cancelled, and a draining run closes them cleanly, so ask whether the
+    // run was interrupted after the slow sync. Run reports the interruption.
+    if err == nil && pipe.Interrupted(ctx) {
+        os.Remove(f.Name())
+        return ctx.Err()
+    }
+    if err == nil {
+        err = os.Rename(f.Name(), n.Path)
+    }
+    if err != nil {
+        os.Remove(f.Name())
+        return err
+    }
+    syncDir(filepath.Dir(n.Path))
+    return nil
+}
 
-    // Multi-worker: group output by sink worker id
-    type buf = []string
-    bufs := make([]buf, workers)
+// syncDir makes a rename in dir durable. Not every platform can sync a
+// directory, so failures are ignored.
+func syncDir(dir string) {
+    d, err := os.Open(dir)
+    if err != nil {
+        return
+    }
+    _ = d.Sync()
+    d.Close()
+}
+
+// encodedItem is an item with its encoded line, on its way to the writer.
+type encodedItem struct {
+    v    any
+    line []byte
+}
 
-    done := make(chan struct{}, workers)
-    for wid := 1; wid <= workers; wid++ {
-        idx := wid - 1
-        go func(wid int, idx int) {
+// write encodes items from in on n.Workers goroutines and hands the lines to
+// put on the calling goroutine, which is thus the only one writing. Lines
+// are written as soon as they are encoded and at most a few per worker are
+// in flight, so memory stays bounded; with one worker the input order is
+// kept. If ticks is not nil, tick is called, also on the calling goroutine,
+// whenever it fires.
+func (n *FileSink) write(ctx context.Context, in <-chan any, enc Encoder, put func(v any, line []byte) error, ticks <-chan time.Time, tick func() error) error {
+    workers := max(n.Workers, 1)
+    fctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    lines := make(chan encodedItem, workers*4)
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
             for {
+                var v any
+                var ok bool
                 select {
-                case <-ctx.Done():
-                    done <- struct{}{}
+                case <-fctx.Done():
                     return
-                case v, ok := <-in:
+                case v, ok = <-in:
                     if !ok {
-                        done <- struct{}{}
                         return
                     }
-                    switch r := v.(type) {
-                    case MD5Result:
-                        if r.Err != nil {
-                            bufs[idx] = append(bufs[idx], fmt.Sprintf("worker=%d ERROR: %s: %v", wid, r.Path, r.Err))
-                        } else {
-                            bufs[idx] = append(bufs[idx], fmt.Sprintf("worker=%d %s", wid, r.String()))
-                        }
-                    default:
-                        bufs[idx] = append(bufs[idx], fmt.Sprintf("worker=%d %v", wid, r))
-                    }
+                }
+                line, err := enc.Append(nil, v)
+                if err != nil {
+                    _ = reportSkipped(n.Warnings, n.ID(), skippedError{err})
+                    continue
+                }
+                select {
+                case <-fctx.Done():
+                    return
+                case lines <- encodedItem{v, line}:
                 }
             }
-        }(wid, idx)
-    }
-
-    // wait workers to finish (on input close or ctx cancel)
-    for i := 0; i < workers; i++ {
-        <-done
+        }()
     }
+    go func() {
+        wg.Wait()
+        close(lines)
+    }()
 
-    // write grouped sections in order
-    for wid := 1; wid <= workers; wid++ {
-        idx := wid - 1
-        if len(bufs[idx]) == 0 { continue }
-        fmt.Fprintf(w, "== worker %d ==\n", wid)
-        for _, line := range bufs[idx] {
-            fmt.Fprintln(w, line)
+    for {
+        select {
+        case <-ticks:
+            if err := tick(); err != nil {
+                return err
+            }
+        case it, ok := <-lines:
+            if !ok {
+                return ctx.Err()
+            }
+            if err := put(it.v, it.line); err != nil {
+                return err
+            }
         }
     }
-    return nil
 }
-
-
@@ -4,15 +4,83 @@ import (
 	"context"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"go-pipes/pkg/pipe"
 )
 
+// FileWalker emits the regular files under each root directory. Entries can
+// be filtered by glob, size, modification time, depth, visibility and
+// gitignore-format files; directories that are excluded are pruned rather
+// than walked. Directory symlinks are followed at most once per target.
 type FileWalker struct {
 	pipe.BaseNode
-	Dirs    []string
+	Dirs []string
+	// Workers is the number of goroutines reading directories, shared by
+	// all roots.
 	Workers int
+
+	// Include keeps only files whose path relative to the root matches one
+	// of the patterns; Exclude drops matching files and prunes matching
+	// directories. Patterns use doublestar syntax, see Glob.
+	Include []string
+	Exclude []string
+	// MinSize and MaxSize bound the file size in bytes; 0 means no bound.
+	MinSize int64
+	MaxSize int64
+	// ModifiedAfter and ModifiedBefore bound the modification time; the
+	// zero time means no bound.
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// MaxDepth limits how deep the walk goes: 1 lists only the root's own
+	// files. 0 means unlimited.
+	MaxDepth int
+	// SkipHidden ignores files and directories whose name starts with a dot.
+	SkipHidden bool
+	// IgnoreFiles names gitignore-format files, such as .gitignore, that are
+	// read in every directory and applied to it and everything below.
+	IgnoreFiles []string
+	// EmitInfo sends a FileInfo record per file instead of its path.
+	EmitInfo bool
+	// Symlinks selects how symbolic links are treated; empty means follow.
+	Symlinks string
+	// OneFilesystem does not descend into directories on another device
+	// than the root, like find -xdev.
+	OneFilesystem bool
+
+	include, exclude []*Glob
+}
+
+// Symlink policies for FileWalker.Symlinks.
+const (
+	SymlinksFollow   = "follow"           // walk linked directories, emit linked files
+	SymlinksSkip     = "skip"             // ignore every link
+	SymlinksEmitLink = "emit-link-itself" // emit each link as an entry, never follow it
+)
+
+// WalkError reports an entry the walker could not read. Walkers pass it to
+// Reject, so OnError decides whether it stops the walk; without a policy
+// it does.
+type WalkError struct {
+	Path string
+	Err  error
+}
+
+func (e WalkError) Error() string { return e.Err.Error() }
+func (e WalkError) Unwrap() error { return e.Err }
+
+var fileWalkerPorts = []pipe.PortSpec{
+	{Name: "files", Dir: pipe.PortOut, Required: true, Type: reflect.TypeFor[string](), Doc: "paths of regular files"},
+}
+
+var fileWalkerInfoPorts = []pipe.PortSpec{
+	{Name: "files", Dir: pipe.PortOut, Required: true, Type: reflect.TypeFor[FileInfo](), Doc: "regular files with their metadata"},
 }
 
 func NewFileWalker(id string, dirOrDirs ...string) *FileWalker {
@@ -20,18 +88,140 @@ func NewFileWalker(id string, dirOrDirs ...string) *FileWalker {
 	if len(dirs) == 0 {
 		dirs = []string{"."}
 	}
-	return &FileWalker{BaseNode: pipe.BaseNode{IDValue: id}, Dirs: dirs, Workers: 1}
+	return &FileWalker{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: fileWalkerPorts}, Dirs: dirs, Workers: 1}
+}
+
+// walkDir is a directory waiting to be read.
+type walkDir struct {
+	path    string // resolved directory path
+	rel     string // slash-separated path below the root, "" for the root
+	depth   int    // depth of the directory's entries, 1 for the root's
+	ignores ignoreStack
+	viaLink bool // reached through a symlinked directory
+}
+
+// walk is the state shared by the workers traversing one root.
+type walk struct {
+	root    string
+	rootDev uint64
+	out     chan any
+
+	mu      sync.Mutex
+	visited map[string]struct{} // resolved dir paths
+}
+
+// visit reports whether dir is seen for the first time in this walk, so
+// that directories reached again through symlinks are read only once.
+func (w *walk) visit(dir string) bool {
+	if rp, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = rp
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, seen := w.visited[dir]; seen {
+		return false
+	}
+	w.visited[dir] = struct{}{}
+	return true
+}
+
+// dirJob is a directory waiting in the queue, with the walk it belongs to.
+type dirJob struct {
+	w   *walk
+	dir walkDir
+}
+
+// dirQueue is an unbounded LIFO of directories shared by the walk workers.
+// It closes itself once no directory is queued or being read, since only a
+// directory being read can add new ones.
+type dirQueue struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	jobs    []dirJob
+	pending int // queued plus being read
+	closed  bool
+}
+
+func newDirQueue() *dirQueue {
+	q := &dirQueue{}
+	q.cond.L = &q.mu
+	return q
+}
+
+func (q *dirQueue) push(j dirJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.jobs = append(q.jobs, j)
+	q.pending++
+	q.cond.Signal()
+}
+
+// pop waits for a directory; it returns false once the queue is closed.
+func (q *dirQueue) pop() (dirJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return dirJob{}, false
+	}
+	j := q.jobs[len(q.jobs)-1]
+	q.jobs = q.jobs[:len(q.jobs)-1]
+	return j, true
+}
+
+// done marks a popped directory as fully read.
+func (q *dirQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		q.closed = true
+		q.cond.Broadcast()
+	}
+}
+
+// abort drops the remaining directories and releases waiting workers.
+func (q *dirQueue) abort() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.jobs = nil
+	q.cond.Broadcast()
 }
 
+// Ports reflects EmitInfo in the type of the files port.
+func (n *FileWalker) Ports() []pipe.PortSpec {
+	if n.EmitInfo {
+		return n.OnError.Ports(fileWalkerInfoPorts)
+	}
+	return n.BaseNode.Ports()
+}
+
+// Start walks all roots with n.Workers goroutines that share one queue of
+// directories, so a single large root is read in parallel too.
 func (n *FileWalker) Start(ctx context.Context) error {
 	defer n.CloseOutputs()
 	out, _ := n.GetOutput("files")
 	if out == nil {
 		return nil
 	}
+	if err := n.compileFilters(); err != nil {
+		return err
+	}
 
-	// Worker routine that walks one root directory with symlink safety
-	walkOne := func(ctx context.Context, root string) error {
+	if len(n.Dirs) == 0 {
+		return nil
+	}
+	q := newDirQueue()
+	// Roots are queued last to first so that a single worker walks them in
+	// order, depth first, as listed.
+	for i := len(n.Dirs) - 1; i >= 0; i-- {
+		root := n.Dirs[i]
 		if root == "" {
 			root = "."
 		}
@@ -41,121 +231,274 @@ func (n *FileWalker) Start(ctx context.Context) error {
 		if rp, err := filepath.EvalSymlinks(root); err == nil {
 			root = rp
 		}
+		w := &walk{root: root, out: out, visited: make(map[string]struct{})}
+		if fi, err := os.Stat(root); err == nil {
+			w.rootDev, _ = fileID(fi)
+		}
+		q.push(dirJob{w: w, dir: walkDir{path: root, depth: 1}})
+	}
+	stop := context.AfterFunc(ctx, q.abort)
+	defer stop()
 
-		visited := make(map[string]struct{}) // resolved dir paths
-		stack := []string{root}
-
-		for len(stack) > 0 {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
-
-			dir := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-
-			resolvedDir := dir
-			if rp, err := filepath.EvalSymlinks(dir); err == nil {
-				resolvedDir = rp
-			}
-			if _, seen := visited[resolvedDir]; seen {
-				continue
+	workers := n.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		failErr error
+	)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				j, ok := q.pop()
+				if !ok {
+					return
+				}
+				var err error
+				if j.w.visit(j.dir.path) {
+					err = n.readDir(ctx, j.w, j.dir, func(d walkDir) { q.push(dirJob{w: j.w, dir: d}) })
+				}
+				q.done()
+				if err != nil {
+					errOnce.Do(func() { failErr = err })
+					q.abort()
+					return
+				}
 			}
-			visited[resolvedDir] = struct{}{}
+		}()
+	}
+	wg.Wait()
+	if failErr != nil {
+		return failErr
+	}
+	return ctx.Err()
+}
 
-			entries, err := os.ReadDir(dir)
-			if err != nil {
+// readDir lists one directory, emits the files that pass the filters and
+// pushes the subdirectories to walk.
+func (n *FileWalker) readDir(ctx context.Context, w *walk, dir walkDir, push func(walkDir)) error {
+	var entries []fs.DirEntry
+	attempts, err := n.OnError.Attempt(ctx, func() (err error) {
+		entries, err = os.ReadDir(dir.path)
+		return err
+	})
+	if err != nil {
+		return n.readError(ctx, dir.path, attempts, err)
+	}
+	ignores := dir.ignores
+	for _, name := range n.IgnoreFiles {
+		file := filepath.Join(dir.path, name)
+		rules, err := readIgnoreFile(file, dir.rel)
+		if err != nil {
+			if err := n.readError(ctx, file, 1, err); err != nil {
 				return err
 			}
-			for _, e := range entries {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-				}
+			continue
+		}
+		if rules != nil {
+			ignores = append(slices.Clip(ignores), rules)
+		}
+	}
 
-				full := filepath.Join(dir, e.Name())
-				mode := e.Type()
+	emit := func(full, rel string, fi fs.FileInfo, viaLink bool) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case w.out <- n.item(full, w.root, rel, fi, viaLink):
+		}
+		return nil
+	}
+
+	for _, e := range entries {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if n.SkipHidden && strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		full := filepath.Join(dir.path, e.Name())
+		rel := path.Join(dir.rel, e.Name())
+		mode := e.Type()
 
-				if mode&fs.ModeSymlink != 0 {
-					fi, err := os.Stat(full)
-					if err != nil {
-						continue
+		if mode&fs.ModeSymlink != 0 {
+			switch n.Symlinks {
+			case SymlinksSkip:
+				continue
+			case SymlinksEmitLink:
+				fi, err := e.Info()
+				if err != nil {
+					if err := n.readError(ctx, full, 1, err); err != nil {
+						return err
 					}
-					if fi.IsDir() {
-						if rp, err := filepath.EvalSymlinks(full); err == nil {
-							stack = append(stack, rp)
-						}
-						continue
+					continue
+				}
+				if !ignores.ignored(rel, false) && n.keep(rel, fi) {
+					if err := emit(full, rel, fi, true); err != nil {
+						return err
 					}
-					if fi.Mode().IsRegular() {
-						select {
-						case <-ctx.Done():
-							return ctx.Err()
-						case out <- full:
-						}
+				}
+				continue
+			}
+			fi, err := os.Stat(full)
+			if err != nil {
+				// A dangling link is dead-lettered but never fails the walk.
+				if n.OnError.DeadLetterPort() != "" {
+					if err := n.readError(ctx, full, 1, err); err != nil {
+						return err
 					}
+				}
+				continue
+			}
+			if fi.IsDir() {
+				if !n.descend(rel, dir.depth) || ignores.ignored(rel, true) || !n.sameFS(w, fi) {
 					continue
 				}
+				if rp, err := filepath.EvalSymlinks(full); err == nil {
+					push(walkDir{path: rp, rel: rel, depth: dir.depth + 1, ignores: ignores, viaLink: true})
+				}
+				continue
+			}
+			if fi.Mode().IsRegular() && !ignores.ignored(rel, false) && n.keep(rel, fi) {
+				if err := emit(full, rel, fi, true); err != nil {
+					return err
+				}
+			}
+			continue
+		}
 
-				if e.IsDir() {
-					next := full
-					if rp, err := filepath.EvalSymlinks(full); err == nil {
-						next = rp
+		if e.IsDir() {
+			if !n.descend(rel, dir.depth) || ignores.ignored(rel, true) {
+				continue
+			}
+			if n.OneFilesystem {
+				fi, err := e.Info()
+				if err != nil {
+					if err := n.readError(ctx, full, 1, err); err != nil {
+						return err
 					}
-					stack = append(stack, next)
 					continue
 				}
-
-				if mode.IsRegular() {
-					select {
-					case <-ctx.Done():
-						return ctx.Err()
-					case out <- full:
-					}
+				if !n.sameFS(w, fi) {
+					continue
 				}
 			}
+			next := full
+			if rp, err := filepath.EvalSymlinks(full); err == nil {
+				next = rp
+			}
+			push(walkDir{path: next, rel: rel, depth: dir.depth + 1, ignores: ignores, viaLink: dir.viaLink})
+			continue
 		}
-		return nil
-	}
 
-	workers := n.Workers
-	if workers <= 0 {
-		workers = 1
-	}
-	// channel of roots to distribute among workers
-	roots := make(chan string)
-	// start workers
-	errCh := make(chan error, workers)
-	for i := 0; i < workers; i++ {
-		go func() {
-			for r := range roots {
-				if err := walkOne(ctx, r); err != nil {
-					errCh <- err
-					return
+		if mode.IsRegular() {
+			if ignores.ignored(rel, false) {
+				continue
+			}
+			var fi fs.FileInfo
+			if n.needInfo() || n.EmitInfo {
+				if fi, err = e.Info(); err != nil {
+					continue // removed since ReadDir
 				}
 			}
-			errCh <- nil
-		}()
-	}
-	// feed roots
-	go func() {
-		for _, d := range n.Dirs {
-			select {
-			case <-ctx.Done():
-				close(roots)
-				return
-			case roots <- d:
+			if !n.keep(rel, fi) {
+				continue
+			}
+			if err := emit(full, rel, fi, dir.viaLink); err != nil {
+				return err
 			}
 		}
-		close(roots)
-	}()
-	// wait workers
-	for i := 0; i < workers; i++ {
-		if err := <-errCh; err != nil {
+	}
+	return nil
+}
+
+// readError rejects an entry the walk could not read; it returns the error
+// to stop the walk, or nil to go on without it.
+func (n *FileWalker) readError(ctx context.Context, path string, attempts int, err error) error {
+	return n.Reject(ctx, path, attempts, WalkError{Path: path, Err: err})
+}
+
+// sameFS reports whether a directory may be entered under OneFilesystem.
+func (n *FileWalker) sameFS(w *walk, fi fs.FileInfo) bool {
+	if !n.OneFilesystem {
+		return true
+	}
+	dev, _ := fileID(fi)
+	return dev == w.rootDev
+}
+
+func (n *FileWalker) compileFilters() error {
+	n.include, n.exclude = nil, nil
+	for _, p := range n.Include {
+		g, err := CompileGlob(p)
+		if err != nil {
+			return err
+		}
+		n.include = append(n.include, g)
+	}
+	for _, p := range n.Exclude {
+		g, err := CompileGlob(p)
+		if err != nil {
 			return err
 		}
+		n.exclude = append(n.exclude, g)
 	}
 	return nil
 }
+
+func matchAny(globs []*Glob, rel string) bool {
+	for _, g := range globs {
+		if g.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// descend reports whether the directory rel, found at depth, is walked.
+func (n *FileWalker) descend(rel string, depth int) bool {
+	if n.MaxDepth > 0 && depth >= n.MaxDepth {
+		return false
+	}
+	return !matchAny(n.exclude, rel)
+}
+
+// item is what the walker emits for a kept file.
+func (n *FileWalker) item(full, root, rel string, fi fs.FileInfo, viaLink bool) any {
+	if !n.EmitInfo {
+		return full
+	}
+	return newFileInfo(full, root, filepath.FromSlash(rel), fi, viaLink)
+}
+
+// needInfo reports whether keep needs the file's size or mtime.
+func (n *FileWalker) needInfo() bool {
+	return n.MinSize > 0 || n.MaxSize > 0 || !n.ModifiedAfter.IsZero() || !n.ModifiedBefore.IsZero()
+}
+
+// keep applies the file filters. fi may be nil when needInfo is false.
+func (n *FileWalker) keep(rel string, fi fs.FileInfo) bool {
+	if len(n.include) > 0 && !matchAny(n.include, rel) {
+		return false
+	}
+	if matchAny(n.exclude, rel) {
+		return false
+	}
+	if fi == nil {
+		return true
+	}
+	switch {
+	case n.MinSize > 0 && fi.Size() < n.MinSize,
+		n.MaxSize > 0 && fi.Size() > n.MaxSize,
+		!n.ModifiedAfter.IsZero() && !fi.ModTime().After(n.ModifiedAfter),
+		!n.ModifiedBefore.IsZero() && !fi.ModTime().Before(n.ModifiedBefore):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package nodes
+
+import (
+	"io/fs"
+	"time"
+)
+
+// FileInfo describes a file found by FileWalker, so downstream nodes need
+// not stat it again. Nodes that take file paths accept it in place of a
+// plain string.
+type FileInfo struct {
+	Path       string // path to open, as emitted in plain string mode
+	Root       string // walk root the file was found under
+	Rel        string // Path relative to Root, through symlinked directories
+	Size       int64
+	Mode       fs.FileMode
+	ModTime    time.Time
+	Device     uint64 // 0 where the platform does not expose it
+	Inode      uint64 // 0 where the platform does not expose it
+	ViaSymlink bool   // the file or one of its parent directories is a symlink
+}
+
+func (fi FileInfo) String() string { return fi.Path }
+
+func newFileInfo(path, root, rel string, info fs.FileInfo, viaSymlink bool) FileInfo {
+	fi := FileInfo{
+		Path:       path,
+		Root:       root,
+		Rel:        rel,
+		Size:       info.Size(),
+		Mode:       info.Mode(),
+		ModTime:    info.ModTime(),
+		ViaSymlink: viaSymlink,
+	}
+	fi.Device, fi.Inode = fileID(info)
+	return fi
+}
+
+// itemPath extracts the file path from an item on a paths port.
+func itemPath(v any) (string, bool) {
+	switch p := v.(type) {
+	case string:
+		return p, true
+	case FileInfo:
+		return p.Path, true
+	case *FileInfo:
+		if p != nil {
+			return p.Path, true
+		}
+	case ArchiveEntry:
+		return p.Path, true
+	}
+	return "", false
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package nodes
+
+import "io/fs"
+
+func fileID(info fs.FileInfo) (dev, ino uint64) {
+	return 0, 0
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package nodes
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func fileID(info fs.FileInfo) (dev, ino uint64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), uint64(st.Ino)
+	}
+	return 0, 0
+}
@@ -0,0 +1,152 @@
+package nodes
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Glob is a compiled path pattern with doublestar semantics: patterns are
+// matched against slash-separated paths segment by segment, a `**` segment
+// matches zero or more whole segments, `*`, `?` and `[...]` work within a
+// segment as in path.Match, and `{a,b}` expands to alternatives.
+type Glob struct {
+	pattern string
+	alts    [][]string
+}
+
+// CompileGlob parses pattern, reporting malformed brackets or braces.
+func CompileGlob(pattern string) (*Glob, error) {
+	expanded, err := expandBraces(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob %q: %w", pattern, err)
+	}
+	g := &Glob{pattern: pattern}
+	for _, p := range expanded {
+		p = strings.TrimPrefix(p, "./")
+		segs := strings.Split(strings.Trim(p, "/"), "/")
+		for i, s := range segs {
+			if s != "**" && strings.Contains(s, "**") {
+				// a**b is not a doublestar; it behaves like a*b.
+				for strings.Contains(s, "**") {
+					s = strings.ReplaceAll(s, "**", "*")
+				}
+				segs[i] = s
+			}
+			if _, err := path.Match(segs[i], ""); err != nil {
+				return nil, fmt.Errorf("glob %q: %w", pattern, err)
+			}
+		}
+		g.alts = append(g.alts, segs)
+	}
+	return g, nil
+}
+
+func (g *Glob) String() string { return g.pattern }
+
+// Match reports whether rel, a path relative to the walk root, matches.
+func (g *Glob) Match(rel string) bool {
+	name := strings.Split(strings.Trim(filepath.ToSlash(rel), "/"), "/")
+	for _, pat := range g.alts {
+		if matchSegments(pat, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for len(pat) > 0 && pat[0] == "**" {
+				pat = pat[1:]
+			}
+			if len(pat) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// expandBraces turns a{b,c}d into abd and acd, recursively. Backslash
+// escapes a brace or comma.
+func expandBraces(p string) ([]string, error) {
+	open := -1
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '{':
+			open = i
+		}
+		if open >= 0 {
+			break
+		}
+	}
+	if open < 0 {
+		if strings.ContainsRune(unescaped(p), '}') {
+			return nil, fmt.Errorf("unmatched '}'")
+		}
+		return []string{p}, nil
+	}
+
+	depth, start := 0, open+1
+	var parts []string
+	for i := open; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				parts = append(parts, p[start:i])
+				start = i + 1
+			}
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			parts = append(parts, p[start:i])
+			var out []string
+			for _, part := range parts {
+				exp, err := expandBraces(p[:open] + part + p[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, exp...)
+			}
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("unmatched '{'")
+}
+
+// unescaped drops backslash-escaped characters from p.
+func unescaped(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' {
+			i++
+			continue
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
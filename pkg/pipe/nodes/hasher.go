@@ -0,0 +1,215 @@
+package nodes
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+
+	"go-pipes/pkg/pipe"
+)
+
+// hashAlgorithms maps the algorithm names accepted in configs to constructors.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+	"crc32":   func() hash.Hash { return crc32.NewIEEE() },
+	"adler32": func() hash.Hash { return adler32.New() },
+}
+
+// HashAlgorithms returns the supported algorithm names in sorted order.
+func HashAlgorithms() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HashResult carries the digests of one file keyed by algorithm name.
+type HashResult struct {
+	Path string
+	Size int64             // bytes read while hashing
+	Sums map[string][]byte // nil digests when Err is set
+	Err  error
+}
+
+// Hex returns the hex digest for algo, or "" if it was not computed.
+func (r HashResult) Hex(algo string) string {
+	return hex.EncodeToString(r.Sums[algo])
+}
+
+// String renders a single digest like md5sum does, and several digests as
+// algo=hex pairs in sorted order.
+func (r HashResult) String() string {
+	if len(r.Sums) == 1 {
+		for _, sum := range r.Sums {
+			return fmt.Sprintf("%x  %s", sum, r.Path)
+		}
+	}
+	algos := make([]string, 0, len(r.Sums))
+	for algo := range r.Sums {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	var b strings.Builder
+	for _, algo := range algos {
+		fmt.Fprintf(&b, "%s=%x ", algo, r.Sums[algo])
+	}
+	b.WriteString(" ")
+	b.WriteString(r.Path)
+	return b.String()
+}
+
+// Hasher computes one or more digests per file in a single read.
+type Hasher struct {
+	pipe.BaseNode
+	Workers    int
+	Algorithms []string
+}
+
+var hasherPorts = []pipe.PortSpec{
+	{Name: "paths", Dir: pipe.PortIn, Required: true, Doc: "files to hash: string paths, FileInfo or ArchiveEntry"},
+	{Name: "results", Dir: pipe.PortOut, Required: true, Type: reflect.TypeFor[HashResult](), Doc: "one result per path"},
+}
+
+func NewHasher(id string, workers int, algorithms ...string) (*Hasher, error) {
+	if workers <= 0 {
+		workers = 10
+	}
+	if len(algorithms) == 0 {
+		algorithms = []string{"sha256"}
+	}
+	for _, a := range algorithms {
+		if _, ok := hashAlgorithms[a]; !ok {
+			return nil, fmt.Errorf("unknown hash algorithm %q (supported: %s)", a, strings.Join(HashAlgorithms(), ", "))
+		}
+	}
+	return &Hasher{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: hasherPorts}, Workers: workers, Algorithms: algorithms}, nil
+}
+
+// Start hashes paths with n.Workers goroutines. Unreadable files are
+// reported in HashResult.Err unless an error policy is set in OnError.
+func (n *Hasher) Start(ctx context.Context) error {
+	defer n.CloseOutputs()
+	in, _ := n.GetInput("paths")
+	out, _ := n.GetOutput("results")
+	if in == nil || out == nil {
+		return nil
+	}
+	return hashWorkers(ctx, &n.BaseNode, n.Workers, in, out, func(item any, path string) (any, error) {
+		res := HashResult{Path: path}
+		var err error
+		res.Size, res.Sums, err = hashFile(item, n.Algorithms)
+		if err != nil {
+			// Keep the algorithm names so that records of failed files
+			// have the same fields as the others.
+			res.Sums = make(map[string][]byte, len(n.Algorithms))
+			for _, a := range n.Algorithms {
+				res.Sums[a] = nil
+			}
+		}
+		res.Err = err
+		return res, err
+	})
+}
+
+// hashFile reads the content of item, a path or ArchiveEntry, once and
+// feeds every requested algorithm through a multi-writer.
+func hashFile(item any, algorithms []string) (int64, map[string][]byte, error) {
+	f, err := openItem(item)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	return hashReader(f, algorithms)
+}
+
+func hashReader(r io.Reader, algorithms []string) (int64, map[string][]byte, error) {
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, a := range algorithms {
+		hashes[i] = hashAlgorithms[a]()
+		writers[i] = hashes[i]
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return size, nil, err
+	}
+	sums := make(map[string][]byte, len(algorithms))
+	for i, a := range algorithms {
+		sums[a] = hashes[i].Sum(nil)
+	}
+	return size, sums, nil
+}
+
+// hashWorkers runs workers goroutines that turn every item from in into a
+// result with compute, which also gets the item's path. A failed result is
+// emitted as is when the node has no error policy, and handed to the policy
+// otherwise.
+func hashWorkers(ctx context.Context, b *pipe.BaseNode, workers int, in <-chan any, out chan any, compute func(item any, path string) (any, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		failErr error
+	)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case p, ok := <-in:
+					if !ok {
+						return
+					}
+					path, _ := itemPath(p)
+					var res any
+					attempts, err := b.OnError.Attempt(ctx, func() (err error) {
+						res, err = compute(p, path)
+						return err
+					})
+					if err != nil && b.OnError.Mode != "" {
+						if err := b.Reject(ctx, path, attempts, err); err != nil {
+							errOnce.Do(func() { failErr = err })
+							cancel()
+							return
+						}
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case out <- res:
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return failErr
+}
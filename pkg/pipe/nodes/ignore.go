@@ -0,0 +1,142 @@
+package nodes
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// ignoreRule is one pattern line of a gitignore-format file.
+type ignoreRule struct {
+	segs    []string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules holds the rules of one ignore file. base is the slash-separated
+// directory of the file relative to the walk root; patterns are matched
+// against paths relative to it.
+type ignoreRules struct {
+	base  string
+	rules []ignoreRule
+}
+
+// ignoreStack is the ignore files in effect for a directory, outermost first.
+type ignoreStack []*ignoreRules
+
+// readIgnoreFile parses a gitignore-format file. A missing file yields nil.
+func readIgnoreFile(file, base string) (*ignoreRules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	r := &ignoreRules{base: base}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if rule, ok := parseIgnoreLine(sc.Text()); ok {
+			r.rules = append(r.rules, rule)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.rules) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// parseIgnoreLine follows gitignore(5): blank lines and # comments are
+// skipped, ! negates, a trailing / matches directories only, a pattern with
+// a / anywhere but the end is anchored to the ignore file's directory, and
+// any other pattern matches at any depth below it.
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	var r ignoreRule
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return r, false
+	}
+	switch {
+	case line[0] == '!':
+		r.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return r, false
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	segs := strings.Split(line, "/")
+	if !anchored {
+		segs = append([]string{"**"}, segs...)
+	}
+	for i, s := range segs {
+		if s == "**" {
+			continue
+		}
+		for strings.Contains(s, "**") {
+			s = strings.ReplaceAll(s, "**", "*")
+		}
+		// path.Match spells a negated class [^...]; git also accepts [!...].
+		s = strings.ReplaceAll(s, "[!", "[^")
+		if _, err := path.Match(s, ""); err != nil {
+			return r, false // git ignores malformed patterns too
+		}
+		segs[i] = s
+	}
+	// A trailing /** matches everything inside, but not the directory itself.
+	if segs[len(segs)-1] == "**" {
+		segs = append(segs[:len(segs)-1], "*", "**")
+	}
+	r.segs = segs
+	return r, true
+}
+
+// match reports whether the last rule matching rel decides, and whether it
+// ignores the path.
+func (r *ignoreRules) match(rel string, isDir bool) (matched, ignored bool) {
+	if r.base != "" {
+		rest, ok := strings.CutPrefix(rel, r.base+"/")
+		if !ok {
+			return false, false
+		}
+		rel = rest
+	}
+	name := strings.Split(rel, "/")
+	for i := len(r.rules) - 1; i >= 0; i-- {
+		rule := r.rules[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.segs, name) {
+			return true, !rule.negate
+		}
+	}
+	return false, false
+}
+
+// ignored applies the stack like git does: rules in deeper files override
+// rules in outer ones, and within a file the last matching rule wins.
+func (s ignoreStack) ignored(rel string, isDir bool) bool {
+	for i := len(s) - 1; i >= 0; i-- {
+		if matched, ignored := s[i].match(rel, isDir); matched {
+			return ignored
+		}
+	}
+	return false
+}
@@ -5,14 +5,14 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
-	"os"
-	"sync"
+	"reflect"
 
 	"go-pipes/pkg/pipe"
 )
 
 type MD5Result struct {
 	Path string
+	Size int64 // bytes read while hashing
 	Sum  [16]byte
 	Err  error
 }
@@ -22,13 +22,20 @@ type MD5Hasher struct {
 	Workers int
 }
 
+var md5HasherPorts = []pipe.PortSpec{
+	{Name: "paths", Dir: pipe.PortIn, Required: true, Doc: "files to hash: string paths, FileInfo or ArchiveEntry"},
+	{Name: "results", Dir: pipe.PortOut, Required: true, Type: reflect.TypeFor[MD5Result](), Doc: "one result per path"},
+}
+
 func NewMD5Hasher(id string, workers int) *MD5Hasher {
 	if workers <= 0 {
 		workers = 10
 	}
-	return &MD5Hasher{BaseNode: pipe.BaseNode{IDValue: id}, Workers: workers}
+	return &MD5Hasher{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: md5HasherPorts}, Workers: workers}
 }
 
+// Start hashes paths with n.Workers goroutines. Unreadable files are
+// reported in MD5Result.Err unless an error policy is set in OnError.
 func (n *MD5Hasher) Start(ctx context.Context) error {
 	defer n.CloseOutputs()
 	in, _ := n.GetInput("paths")
@@ -36,45 +43,27 @@ func (n *MD5Hasher) Start(ctx context.Context) error {
 	if in == nil || out == nil {
 		return nil
 	}
+	return hashWorkers(ctx, &n.BaseNode, n.Workers, in, out, func(item any, path string) (any, error) {
+		res := MD5Result{Path: path}
+		var err error
+		res.Size, res.Sum, err = md5File(item)
+		res.Err = err
+		return res, err
+	})
+}
 
-	var wg sync.WaitGroup
-	wg.Add(n.Workers)
-	for i := 0; i < n.Workers; i++ {
-		go func() {
-			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case p, ok := <-in:
-					if !ok {
-						return
-					}
-					path, _ := p.(string)
-					res := MD5Result{Path: path}
-					f, err := os.Open(path)
-					if err != nil {
-						res.Err = err
-					} else {
-						h := md5.New()
-						if _, err := io.Copy(h, f); err != nil {
-							res.Err = err
-						} else {
-							copy(res.Sum[:], h.Sum(nil))
-						}
-						_ = f.Close()
-					}
-					select {
-					case <-ctx.Done():
-						return
-					case out <- res:
-					}
-				}
-			}
-		}()
+func md5File(item any) (size int64, sum [16]byte, err error) {
+	f, err := openItem(item)
+	if err != nil {
+		return 0, sum, err
+	}
+	defer f.Close()
+	h := md5.New()
+	if size, err = io.Copy(h, f); err != nil {
+		return size, sum, err
 	}
-	wg.Wait()
-	return nil
+	copy(sum[:], h.Sum(nil))
+	return size, sum, nil
 }
 
 func (r MD5Result) String() string {
@@ -2,7 +2,8 @@ package nodes
 
 import (
 	"context"
-	"fmt"
+	"io"
+	"os"
 	"sync"
 
 	"go-pipes/pkg/pipe"
@@ -12,27 +13,50 @@ type Printer struct {
 	pipe.BaseNode
 	Quiet   bool
 	Workers int
+	Format  string // see NewEncoder; empty means text
+	// Warnings receives a note per item the format cannot represent, such
+	// as a failed result in coreutils format; nil discards them.
+	Warnings io.Writer
+}
+
+var printerPorts = []pipe.PortSpec{
+	{Name: "in", Dir: pipe.PortIn, Required: true, Doc: "items to print to stdout"},
 }
 
 func NewPrinter(id string, quiet bool) *Printer {
-	return &Printer{BaseNode: pipe.BaseNode{IDValue: id}, Quiet: quiet, Workers: 1}
+	return &Printer{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: printerPorts}, Quiet: quiet, Workers: 1, Format: FormatText}
 }
 
+// Start encodes items on n.Workers goroutines and writes whole lines to
+// stdout, so lines from different workers never interleave.
 func (n *Printer) Start(ctx context.Context) error {
 	defer n.CloseOutputs()
 	in, _ := n.GetInput("in")
 	if in == nil {
 		return nil
 	}
+	enc, err := NewEncoder(n.Format)
+	if err != nil {
+		return err
+	}
+	rw := newRecordWriter(enc, os.Stdout)
 	workers := n.Workers
 	if workers <= 0 {
 		workers = 1
 	}
-	var wg sync.WaitGroup
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		failErr error
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	wg.Add(workers)
-	for wid := 1; wid <= workers; wid++ {
-		go func(wid int) {
+	for i := 0; i < workers; i++ {
+		go func() {
 			defer wg.Done()
+			var buf []byte
+			var err error
 			for {
 				select {
 				case <-ctx.Done():
@@ -44,20 +68,21 @@ func (n *Printer) Start(ctx context.Context) error {
 					if n.Quiet {
 						continue
 					}
-					switch r := v.(type) {
-					case MD5Result:
-						if r.Err != nil {
-							fmt.Printf("worker=%d ERROR: %s: %v\n", wid, r.Path, r.Err)
-						} else {
-							fmt.Printf("worker=%d %s\n", wid, r.String())
-						}
-					default:
-						fmt.Printf("worker=%d %v\n", wid, r)
+					buf, err = enc.Append(buf[:0], v)
+					if err == nil {
+						err = rw.writeLine(v, buf)
+					} else {
+						err = skippedError{err}
+					}
+					if err = reportSkipped(n.Warnings, n.ID(), err); err != nil {
+						errOnce.Do(func() { failErr = err })
+						cancel()
+						return
 					}
 				}
 			}
-		}(wid)
+		}()
 	}
 	wg.Wait()
-	return nil
+	return failErr
 }
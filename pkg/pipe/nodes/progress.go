@@ -0,0 +1,203 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-pipes/pkg/pipe"
+)
+
+// Progress passes items through unchanged while reporting progress.
+// Items on the paths lane count as discovered files; items on the results
+// lane count as hashed files, and their sizes as hashed bytes. Once the
+// paths lane closes, the remaining work is known and an ETA is shown.
+type Progress struct {
+	pipe.BaseNode
+	Interval time.Duration
+	// Mode is "tty" for a self-updating status line, "log" for periodic
+	// lines, or "auto" (default) to pick tty when Output is a terminal.
+	Mode   string
+	Output io.Writer
+
+	discovered atomic.Int64
+	hashed     atomic.Int64
+	bytes      atomic.Int64
+	walkDone   atomic.Bool
+}
+
+var progressPorts = []pipe.PortSpec{
+	{Name: "paths", Dir: pipe.PortIn, Lane: "paths", Doc: "discovered files, forwarded to paths_out"},
+	{Name: "paths_out", Dir: pipe.PortOut, Lane: "paths"},
+	{Name: "results", Dir: pipe.PortIn, Lane: "results", Doc: "hashed files, forwarded to results_out"},
+	{Name: "results_out", Dir: pipe.PortOut, Lane: "results"},
+}
+
+func NewProgress(id string) *Progress {
+	return &Progress{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: progressPorts}, Interval: time.Second, Mode: "auto"}
+}
+
+func (n *Progress) Start(ctx context.Context) error {
+	defer n.CloseOutputs()
+	paths, _ := n.GetInput("paths")
+	results, _ := n.GetInput("results")
+	if paths == nil && results == nil {
+		return nil
+	}
+	if paths == nil {
+		n.walkDone.Store(true)
+	}
+	w := n.Output
+	if w == nil {
+		w = os.Stderr
+	}
+	tty := n.Mode == "tty" || (n.Mode != "log" && isTerminal(w))
+	interval := n.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	began := time.Now()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	lane := func(in <-chan any, outPort string, count func(v any)) {
+		defer wg.Done()
+		out, _ := n.GetOutput(outPort)
+		for {
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				count(v)
+				if out == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				case out <- v:
+				}
+			}
+		}
+	}
+	if paths != nil {
+		wg.Add(1)
+		go func() {
+			lane(paths, "paths_out", func(any) { n.discovered.Add(1) })
+			n.walkDone.Store(true)
+		}()
+	}
+	if results != nil {
+		wg.Add(1)
+		go lane(results, "results_out", func(v any) {
+			n.hashed.Add(1)
+			if size, ok := resultSize(v); ok {
+				n.bytes.Add(size)
+			}
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.report(w, tty, time.Since(began), false)
+		case <-done:
+			n.report(w, tty, time.Since(began), true)
+			select {
+			case err := <-errs:
+				return err
+			default:
+				return nil
+			}
+		}
+	}
+}
+
+func (n *Progress) report(w io.Writer, tty bool, elapsed time.Duration, final bool) {
+	discovered := n.discovered.Load()
+	hashed := n.hashed.Load()
+	bytes := n.bytes.Load()
+	secs := elapsed.Seconds()
+
+	var b strings.Builder
+	if n.walkDone.Load() && discovered == 0 {
+		fmt.Fprintf(&b, "hashed=%d", hashed)
+	} else {
+		fmt.Fprintf(&b, "discovered=%d hashed=%d", discovered, hashed)
+	}
+	fmt.Fprintf(&b, " bytes=%s", formatBytes(bytes))
+	if secs > 0 {
+		fmt.Fprintf(&b, " rate=%.1f files/s %s/s", float64(hashed)/secs, formatBytes(int64(float64(bytes)/secs)))
+	}
+	if final {
+		fmt.Fprintf(&b, " elapsed=%s", elapsed.Round(time.Second))
+	} else if discovered > 0 && hashed > 0 {
+		eta := time.Duration(float64(discovered-hashed) / (float64(hashed) / secs) * float64(time.Second))
+		if n.walkDone.Load() {
+			fmt.Fprintf(&b, " eta=%s", eta.Round(time.Second))
+		} else {
+			// More files may still be discovered, so this is a lower bound.
+			fmt.Fprintf(&b, " eta>=%s", eta.Round(time.Second))
+		}
+	}
+
+	if tty {
+		end := ""
+		if final {
+			end = "\n"
+		}
+		fmt.Fprintf(w, "\r\033[K%s: %s%s", n.ID(), b.String(), end)
+		return
+	}
+	fmt.Fprintf(w, "%s %s: %s\n", time.Now().Format("2006/01/02 15:04:05"), n.ID(), b.String())
+}
+
+// resultSize returns the number of bytes a hashing result covered.
+func resultSize(v any) (int64, bool) {
+	switch r := v.(type) {
+	case MD5Result:
+		return r.Size, r.Err == nil
+	case HashResult:
+		return r.Size, r.Err == nil
+	}
+	return 0, false
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,443 @@
+package nodes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Rotation splits FileSink output into segments. A segment is closed once
+// it reaches MaxBytes or MaxRecords, or once it is Interval old; zero values
+// disable a limit. Segment names come from the sink's Path used as a
+// text/template, see SegmentName.
+type Rotation struct {
+	MaxBytes   int64 // counted before compression
+	MaxRecords int
+	Interval   time.Duration
+	Gzip       bool // compress closed segments to <name>.gz
+	Keep       int  // closed segments to keep, oldest removed first; 0 keeps all
+}
+
+func (r Rotation) enabled() bool {
+	return r.MaxBytes > 0 || r.MaxRecords > 0 || r.Interval > 0
+}
+
+// SegmentName is the data available to a segment path template:
+// {{.Seq}} counts segments from 1, {{.Time}} is the UTC opening time as
+// 20060102T150405Z, and {{.Start}} is the same time for custom layouts.
+// Every template must use {{.Seq}}, which keeps segment names apart.
+type SegmentName struct {
+	Seq   int
+	Time  string
+	Start time.Time
+}
+
+var templateAction = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// ParseSegmentTemplate parses a segment path and renders it once, so that
+// unknown fields fail early. A path without template actions gets -{{.Seq}}
+// inserted before its extension, e.g. out-{{.Seq}}.jsonl.gz.
+func ParseSegmentTemplate(path string) (*template.Template, error) {
+	tmpl, _, err := parseSegmentPath(segmentPath(path))
+	return tmpl, err
+}
+
+// segmentPattern matches the segment names of a template, relative to the
+// directory holding its first action, so that retention never touches
+// other files. {{.Seq}} and {{.Time}} match exactly what they render; any other
+// action matches the runs of digits and letters of a sample rendering.
+type segmentPattern struct {
+	dir    string
+	re     string
+	nested bool // names reach into subdirectories of dir
+}
+
+func parseSegmentPath(path string) (*template.Template, segmentPattern, error) {
+	var pat segmentPattern
+	tmpl, err := template.New("segment").Parse(path)
+	if err != nil {
+		return nil, pat, err
+	}
+	if err := tmpl.Execute(io.Discard, SegmentName{Seq: 1}); err != nil {
+		return nil, pat, err
+	}
+
+	rest := path
+	loc := templateAction.FindStringIndex(path)
+	if i := strings.LastIndex(path[:loc[0]], "/"); i >= 0 {
+		pat.dir, rest = path[:max(i, 1)], path[i+1:]
+	} else {
+		pat.dir = "."
+	}
+	sample := SegmentName{Seq: 1, Time: "20060102T150405Z", Start: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)}
+	var re strings.Builder
+	seq := false
+	last := 0
+	for _, m := range templateAction.FindAllStringIndex(rest, -1) {
+		re.WriteString(regexp.QuoteMeta(rest[last:m[0]]))
+		last = m[1]
+		action := rest[m[0]:m[1]]
+		field := strings.TrimSpace(strings.Trim(action, "{}-"))
+		seq = seq || strings.Contains(field, ".Seq")
+		switch field {
+		case ".Seq":
+			re.WriteString(`\d+`)
+			continue
+		case ".Time":
+			re.WriteString(`\d{8}T\d{6}Z`)
+			continue
+		}
+		t, err := template.New("action").Parse(action)
+		if err != nil {
+			return nil, pat, fmt.Errorf("segment path actions must each be a single field: %s", action)
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, sample); err != nil {
+			return nil, pat, err
+		}
+		re.WriteString(sampleShape(b.String()))
+	}
+	re.WriteString(regexp.QuoteMeta(rest[last:]))
+	pat.nested = strings.Contains(rest, "/")
+	if !seq {
+		return nil, pat, fmt.Errorf("segment path %q must use {{.Seq}} to keep segment names apart", path)
+	}
+	pat.re = re.String()
+	return tmpl, pat, nil
+}
+
+var sampleRuns = regexp.MustCompile(`[0-9]+|[A-Za-z]+|[^0-9A-Za-z]+`)
+
+// sampleShape turns a rendered value into a regexp matching runs of digits
+// and letters of any length, and the other characters as they are.
+func sampleShape(s string) string {
+	var b strings.Builder
+	for _, run := range sampleRuns.FindAllString(s, -1) {
+		switch c := run[0]; {
+		case c >= '0' && c <= '9':
+			b.WriteString(`\d+`)
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			b.WriteString(`[A-Za-z]+`)
+		default:
+			b.WriteString(regexp.QuoteMeta(run))
+		}
+	}
+	return b.String()
+}
+
+func segmentPath(path string) string {
+	if templateAction.MatchString(path) {
+		return path
+	}
+	stem, zext := path, ""
+	for _, z := range []string{".gz", ".zz"} {
+		if strings.HasSuffix(path, z) {
+			stem, zext = strings.TrimSuffix(path, z), z
+		}
+	}
+	ext := filepath.Ext(stem)
+	return strings.TrimSuffix(stem, ext) + "-{{.Seq}}" + ext + zext
+}
+
+// segmentWriter writes records into rotating segment files. Closed segments
+// are compressed and pruned in order by one background goroutine.
+type segmentWriter struct {
+	rot    Rotation
+	enc    Encoder
+	codec  string // compression of the segments themselves
+	tmpl   *template.Template
+	dir    string         // directory searched for old segments
+	match  *regexp.Regexp // segment names to prune, relative to dir
+	nested bool
+
+	seq     int
+	f       *os.File
+	w       *bufio.Writer
+	z       io.WriteCloser
+	rw      *recordWriter
+	bytes   int64
+	records int
+	opened  time.Time
+
+	closed chan string
+	done   chan struct{}
+	mu     sync.Mutex
+	open   string // current segment, skipped by prune
+	bgErr  error
+}
+
+func newSegmentWriter(path string, rot Rotation, enc Encoder, codec string) (*segmentWriter, error) {
+	if rot.Gzip && codec != CompressNone {
+		return nil, fmt.Errorf("segments are already compressed with %s", codec)
+	}
+	tmpl, pat, err := parseSegmentPath(segmentPath(path))
+	if err != nil {
+		return nil, err
+	}
+	// With Gzip only compressed segments count; plain ones may still be
+	// queued for compression.
+	re := pat.re
+	if rot.Gzip {
+		re += `\.gz`
+	}
+	s := &segmentWriter{
+		rot: rot, enc: enc, codec: codec, tmpl: tmpl,
+		dir:    pat.dir,
+		match:  regexp.MustCompile("^" + re + "$"),
+		nested: pat.nested,
+		closed: make(chan string, 16),
+		done:   make(chan struct{}),
+	}
+	go s.background()
+	return s, nil
+}
+
+// writeLine writes the encoded line of v to the current segment, rotating
+// first if it is full.
+func (s *segmentWriter) writeLine(v any, line []byte) error {
+	if s.f != nil && s.full() {
+		if err := s.closeSegment(); err != nil {
+			return err
+		}
+	}
+	if s.f == nil {
+		if err := s.openSegment(); err != nil {
+			return err
+		}
+	}
+	if err := s.rw.writeLine(v, line); err != nil {
+		return err
+	}
+	s.records++
+	return nil
+}
+
+func (s *segmentWriter) full() bool {
+	return (s.rot.MaxBytes > 0 && s.bytes >= s.rot.MaxBytes) ||
+		(s.rot.MaxRecords > 0 && s.records >= s.rot.MaxRecords) ||
+		(s.rot.Interval > 0 && time.Since(s.opened) >= s.rot.Interval)
+}
+
+// tick closes a non-empty segment whose interval has passed, so that
+// segments are handed over even when the input goes quiet.
+func (s *segmentWriter) tick() error {
+	if s.f != nil && s.records > 0 && time.Since(s.opened) >= s.rot.Interval {
+		return s.closeSegment()
+	}
+	return nil
+}
+
+const maxSegmentTries = 10000
+
+// openSegment creates the next segment. Names that already exist, e.g. from
+// an earlier run, are skipped rather than overwritten.
+func (s *segmentWriter) openSegment() error {
+	for tries := 0; ; tries++ {
+		s.seq++
+		now := time.Now().UTC()
+		var b strings.Builder
+		if err := s.tmpl.Execute(&b, SegmentName{Seq: s.seq, Time: now.Format("20060102T150405Z"), Start: now}); err != nil {
+			return err
+		}
+		name := b.String()
+		if _, err := os.Lstat(name + ".gz"); err == nil && tries < maxSegmentTries {
+			continue
+		}
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, fs.ErrExist) && tries < maxSegmentTries {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.open = name
+		s.mu.Unlock()
+		s.f, s.opened, s.bytes, s.records = f, now, 0, 0
+		s.w = bufio.NewWriter(f)
+		if s.z, err = newCompressor(s.w, s.codec); err != nil {
+			f.Close()
+			return err
+		}
+		s.rw = newRecordWriter(s.enc, countingWriter{s.z, &s.bytes})
+		return nil
+	}
+}
+
+// closeSegment closes the current segment and queues it for compression
+// and retention.
+func (s *segmentWriter) closeSegment() error {
+	err := s.z.Close()
+	if ferr := s.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	name := s.f.Name()
+	s.f, s.w, s.z, s.rw = nil, nil, nil, nil
+	s.mu.Lock()
+	s.open = ""
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.closed <- name
+	return nil
+}
+
+func (s *segmentWriter) background() {
+	defer close(s.done)
+	for name := range s.closed {
+		if s.rot.Gzip {
+			if err := gzipFile(name); err != nil {
+				s.mu.Lock()
+				if s.bgErr == nil {
+					s.bgErr = fmt.Errorf("gzip %s: %w", name, err)
+				}
+				s.mu.Unlock()
+				continue
+			}
+		}
+		s.prune()
+	}
+}
+
+// prune removes the oldest closed segments beyond Keep. Segments are the
+// files whose name matches the template, so those of earlier runs count as
+// well.
+func (s *segmentWriter) prune() {
+	if s.rot.Keep <= 0 {
+		return
+	}
+	s.mu.Lock()
+	open := filepath.Clean(s.open)
+	s.mu.Unlock()
+	type segment struct {
+		name string
+		mod  time.Time
+	}
+	var segs []segment
+	_ = filepath.WalkDir(s.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == open {
+			return nil
+		}
+		if d.IsDir() {
+			if name != s.dir && !s.nested {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, name)
+		if err != nil || !s.match.MatchString(filepath.ToSlash(rel)) {
+			return nil
+		}
+		if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() {
+			segs = append(segs, segment{name, fi.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(segs, func(i, j int) bool {
+		if !segs[i].mod.Equal(segs[j].mod) {
+			return segs[i].mod.After(segs[j].mod)
+		}
+		return segs[i].name > segs[j].name
+	})
+	for _, seg := range segs[min(s.rot.Keep, len(segs)):] {
+		_ = os.Remove(seg.name)
+	}
+}
+
+// close closes the last segment and waits for the background work.
+func (s *segmentWriter) close() error {
+	var err error
+	if s.f != nil {
+		err = s.closeSegment()
+	}
+	close(s.closed)
+	<-s.done
+	if err == nil {
+		err = s.bgErr
+	}
+	return err
+}
+
+// gzipFile replaces name with name.gz.
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dir, base := filepath.Dir(name), filepath.Base(name)
+	dst, err := os.CreateTemp(dir, base+".gz.tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	if fi, err := src.Stat(); err == nil {
+		_ = dst.Chmod(fi.Mode().Perm())
+	}
+	zw, err := newCompressor(dst, CompressGzip)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(dst.Name(), name+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// writeRotating is FileSink's write loop when rotation is on. The last
+// segment is closed, and so handed over, even when the run is cancelled.
+func (n *FileSink) writeRotating(ctx context.Context, in <-chan any, enc Encoder, codec string) error {
+	s, err := newSegmentWriter(n.Path, n.Rotation, enc, codec)
+	if err != nil {
+		return err
+	}
+	var ticks <-chan time.Time
+	if n.Rotation.Interval > 0 {
+		t := time.NewTicker(min(n.Rotation.Interval, time.Second))
+		defer t.Stop()
+		ticks = t.C
+	}
+	err = n.write(ctx, in, enc, s.writeLine, ticks, s.tick)
+	if cerr := s.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
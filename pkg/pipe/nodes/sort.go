@@ -0,0 +1,322 @@
+package nodes
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+
+	"go-pipes/pkg/pipe"
+)
+
+// Sort keys for Sort.Key.
+const (
+	SortByPath = "path" // the file path of results and paths, see itemKey
+	SortByText = "text" // the item rendered with %v
+)
+
+// Sort emits its input ordered by key once the input closes, so that
+// output does not depend on worker scheduling. At most MaxItems items are
+// held in memory; larger inputs are sorted in runs spilled to temporary
+// files and merged, so memory stays bounded for any input size. Items with
+// equal keys keep their arrival order.
+type Sort struct {
+	pipe.BaseNode
+	Key      string
+	MaxItems int
+	TempDir  string // directory for spill files; empty means os.TempDir
+}
+
+var sortPorts = []pipe.PortSpec{
+	{Name: "in", Dir: pipe.PortIn, Required: true, Doc: "items to sort"},
+	{Name: "out", Dir: pipe.PortOut, Required: true, Doc: "the same items, sorted"},
+}
+
+func NewSort(id string) *Sort {
+	return &Sort{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: sortPorts}, Key: SortByPath, MaxItems: 100000}
+}
+
+// sortRecord is an item with its key and arrival number, as kept in memory
+// and in spill files.
+type sortRecord struct {
+	Key   string
+	Seq   int64
+	Value any
+}
+
+func (n *Sort) Start(ctx context.Context) error {
+	defer n.CloseOutputs()
+	in, _ := n.GetInput("in")
+	out, _ := n.GetOutput("out")
+	if in == nil || out == nil {
+		return nil
+	}
+	limit := n.MaxItems
+	if limit <= 0 {
+		limit = 100000
+	}
+
+	var (
+		buf  []sortRecord
+		runs []string
+		dir  string
+		seq  int64
+	)
+	defer func() {
+		if dir != "" {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+	spill := func() error {
+		if dir == "" {
+			var err error
+			if dir, err = os.MkdirTemp(n.TempDir, "gopipes-sort-"); err != nil {
+				return err
+			}
+		}
+		path, err := writeRun(dir, len(runs), buf)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, path)
+		buf = buf[:0]
+		return nil
+	}
+
+	for {
+		var v any
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case v, ok = <-in:
+		}
+		if !ok {
+			break
+		}
+		buf = append(buf, sortRecord{Key: n.key(v), Seq: seq, Value: v})
+		seq++
+		if len(buf) >= limit {
+			sortRecords(buf)
+			if err := spill(); err != nil {
+				return err
+			}
+		}
+	}
+	sortRecords(buf)
+
+	send := func(v any) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- v:
+		}
+		return nil
+	}
+	if len(runs) == 0 {
+		for _, r := range buf {
+			if err := send(r.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(buf) > 0 {
+		if err := spill(); err != nil {
+			return err
+		}
+	}
+	return mergeRuns(runs, send)
+}
+
+func (n *Sort) key(v any) string {
+	if n.Key == SortByText {
+		return fmt.Sprint(v)
+	}
+	return itemKey(v)
+}
+
+// itemKey is the path an item is about, or its %v rendering for other items.
+func itemKey(v any) string {
+	switch r := v.(type) {
+	case string:
+		return r
+	case FileInfo:
+		return r.Path
+	case MD5Result:
+		return r.Path
+	case HashResult:
+		return r.Path
+	case VerifyResult:
+		return r.Path
+	case ChecksumEntry:
+		return r.Path
+	case WalkError:
+		return r.Path
+	case ArchiveEntry:
+		return r.Path
+	case DuplicateGroup:
+		if len(r.Paths) > 0 {
+			return r.Paths[0]
+		}
+	case pipe.DeadLetter:
+		return itemKey(r.Item)
+	}
+	return fmt.Sprint(v)
+}
+
+func sortRecords(rs []sortRecord) {
+	sort.Slice(rs, func(i, j int) bool { return lessRecord(rs[i], rs[j]) })
+}
+
+func lessRecord(a, b sortRecord) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.Seq < b.Seq
+}
+
+func init() {
+	for _, v := range []any{
+		FileInfo{}, MD5Result{}, HashResult{}, VerifyResult{}, VerifySummary{},
+		ChecksumEntry{}, WalkError{}, DuplicateGroup{}, ArchiveEntry{}, pipe.DeadLetter{}, spillError{},
+	} {
+		gob.Register(v)
+	}
+}
+
+// spillError stands in for error values in spilled items: arbitrary error
+// types cannot be gob-encoded, so only the message survives, plus whether
+// the error matched fs.ErrNotExist.
+type spillError struct {
+	Msg      string
+	NotExist bool
+}
+
+func (e spillError) Error() string { return e.Msg }
+
+func (e spillError) Is(target error) bool { return e.NotExist && target == fs.ErrNotExist }
+
+var errorType = reflect.TypeFor[error]()
+
+// spillable replaces the error fields of a struct item with spillError.
+func spillable(v any) any {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Struct {
+		return v
+	}
+	cp := reflect.New(rv.Type()).Elem()
+	cp.Set(rv)
+	for i := 0; i < cp.NumField(); i++ {
+		f := cp.Field(i)
+		if f.Type() != errorType || f.IsNil() || !f.CanSet() {
+			continue
+		}
+		err := f.Interface().(error)
+		f.Set(reflect.ValueOf(error(spillError{Msg: err.Error(), NotExist: errors.Is(err, fs.ErrNotExist)})))
+	}
+	return cp.Interface()
+}
+
+// writeRun writes sorted records to a new spill file in dir.
+func writeRun(dir string, index int, rs []sortRecord) (string, error) {
+	f, err := os.CreateTemp(dir, fmt.Sprintf("run-%d-", index))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, r := range rs {
+		r.Value = spillable(r.Value)
+		if err := enc.Encode(&r); err != nil {
+			return "", fmt.Errorf("spill %T: %w", r.Value, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// runReader yields the records of one spill file in order.
+type runReader struct {
+	f   *os.File
+	dec *gob.Decoder
+	cur sortRecord
+}
+
+func (r *runReader) next() (bool, error) {
+	r.cur = sortRecord{}
+	if err := r.dec.Decode(&r.cur); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return lessRecord(h[i].cur, h[j].cur) }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns merges the spill files and sends the values in order.
+func mergeRuns(paths []string, send func(any) error) error {
+	h := make(runHeap, 0, len(paths))
+	defer func() {
+		for _, r := range h {
+			r.f.Close()
+		}
+	}()
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		r := &runReader{f: f, dec: gob.NewDecoder(bufio.NewReader(f))}
+		ok, err := r.next()
+		if err != nil || !ok {
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			continue
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		r := h[0]
+		if err := send(r.cur.Value); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.f.Name(), err)
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			r.f.Close()
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
     "fmt"
     "os"
     "path/filepath"
+    "reflect"
     "strings"
 
     "go-pipes/pkg/pipe"
@@ -17,8 +18,12 @@ type StdinSource struct {
     AllowEmpty bool
 }
 
+var stdinSourcePorts = []pipe.PortSpec{
+    {Name: "paths", Dir: pipe.PortOut, Required: true, Type: reflect.TypeFor[string](), Doc: "single path read from stdin"},
+}
+
 func NewStdinSource(id string, prompt string, allowEmpty bool) *StdinSource {
-    return &StdinSource{BaseNode: pipe.BaseNode{IDValue: id}, Prompt: prompt, AllowEmpty: allowEmpty}
+    return &StdinSource{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: stdinSourcePorts}, Prompt: prompt, AllowEmpty: allowEmpty}
 }
 
 func (n *StdinSource) Start(ctx context.Context) error {
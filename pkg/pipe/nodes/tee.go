@@ -8,11 +8,19 @@ import (
 
 // Tee duplicates items from input to two outputs: out1 and out2.
 // Backpressure applies if either downstream is slow.
+//
+// Deprecated: connect the output to several edges with mode broadcast instead.
 type Tee struct {
 	pipe.BaseNode
 }
 
-func NewTee(id string) *Tee { return &Tee{BaseNode: pipe.BaseNode{IDValue: id}} }
+var teePorts = []pipe.PortSpec{
+	{Name: "in", Dir: pipe.PortIn, Required: true},
+	{Name: "out1", Dir: pipe.PortOut, Required: true, Doc: "copy of every input item"},
+	{Name: "out2", Dir: pipe.PortOut, Required: true, Doc: "copy of every input item"},
+}
+
+func NewTee(id string) *Tee { return &Tee{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: teePorts}} }
 
 func (n *Tee) Start(ctx context.Context) error {
 	defer n.CloseOutputs()
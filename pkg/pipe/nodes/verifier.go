@@ -0,0 +1,211 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"reflect"
+	"slices"
+
+	"go-pipes/pkg/pipe"
+)
+
+// Verification statuses, named as md5sum -c prints them.
+const (
+	StatusOK      = "OK"
+	StatusFailed  = "FAILED"
+	StatusMissing = "MISSING"
+)
+
+// VerifyResult is the outcome of checking one manifest entry.
+type VerifyResult struct {
+	Name      string
+	Path      string
+	Algorithm string
+	Status    string
+	Expected  []byte
+	Actual    []byte
+	Err       error
+}
+
+func (r VerifyResult) String() string {
+	switch {
+	case r.Status == StatusFailed && r.Err != nil:
+		return fmt.Sprintf("%s: FAILED open or read: %v", r.Name, r.Err)
+	case r.Status == StatusMissing && r.Err != nil:
+		return fmt.Sprintf("%s: MISSING: %v", r.Name, r.Err)
+	}
+	return fmt.Sprintf("%s: %s", r.Name, r.Status)
+}
+
+// VerifySummary counts verification outcomes once all entries are checked.
+type VerifySummary struct {
+	Total   int
+	OK      int
+	Failed  int
+	Missing int
+}
+
+func (s VerifySummary) String() string {
+	return fmt.Sprintf("checked %d file(s): %d OK, %d FAILED, %d MISSING", s.Total, s.OK, s.Failed, s.Missing)
+}
+
+// Verifier compares freshly computed digests with the expected ones from a
+// checksum manifest. It emits one VerifyResult per expected entry and a
+// VerifySummary at the end, and fails when anything did not match, so the
+// pipeline exits non-zero like md5sum -c.
+type Verifier struct {
+	pipe.BaseNode
+}
+
+var verifierPorts = []pipe.PortSpec{
+	{Name: "expected", Dir: pipe.PortIn, Required: true, Type: reflect.TypeFor[ChecksumEntry](), Doc: "entries from a checksum manifest"},
+	{Name: "actual", Dir: pipe.PortIn, Required: true, Doc: "HashResult or MD5Result for the listed files"},
+	{Name: "results", Dir: pipe.PortOut, Required: true, Type: reflect.TypeFor[VerifyResult](), Doc: "one result per entry"},
+	{Name: "summary", Dir: pipe.PortOut, Type: reflect.TypeFor[VerifySummary](), Doc: "totals, sent once at the end"},
+}
+
+func NewVerifier(id string) *Verifier {
+	return &Verifier{BaseNode: pipe.BaseNode{IDValue: id, PortSpecs: verifierPorts}}
+}
+
+// computed is a hashing result reduced to what the verifier needs.
+type computed struct {
+	sums map[string][]byte
+	err  error
+}
+
+func toComputed(v any) (string, computed, bool) {
+	switch r := v.(type) {
+	case HashResult:
+		return r.Path, computed{sums: r.Sums, err: r.Err}, true
+	case MD5Result:
+		c := computed{err: r.Err}
+		if r.Err == nil {
+			c.sums = map[string][]byte{"md5": r.Sum[:]}
+		}
+		return r.Path, c, true
+	}
+	return "", computed{}, false
+}
+
+func (n *Verifier) Start(ctx context.Context) error {
+	defer n.CloseOutputs()
+	expected, _ := n.GetInput("expected")
+	actual, _ := n.GetInput("actual")
+	out, _ := n.GetOutput("results")
+	if expected == nil || actual == nil || out == nil {
+		return nil
+	}
+	summaryOut, _ := n.GetOutput("summary")
+
+	var sum VerifySummary
+	waiting := make(map[string][]ChecksumEntry) // expected, no result yet
+	results := make(map[string][]computed)      // results, no entry yet
+
+	emit := func(e ChecksumEntry, c computed) error {
+		r := VerifyResult{Name: e.Name, Path: e.Path, Algorithm: e.Algorithm, Expected: e.Sum}
+		switch {
+		case c.err != nil && errors.Is(c.err, fs.ErrNotExist):
+			r.Status, r.Err = StatusMissing, c.err
+		case c.err != nil:
+			r.Status, r.Err = StatusFailed, c.err
+		case c.sums[e.Algorithm] == nil:
+			r.Status, r.Err = StatusFailed, fmt.Errorf("%s digest was not computed", e.Algorithm)
+		default:
+			r.Actual = c.sums[e.Algorithm]
+			r.Status = StatusFailed
+			if bytes.Equal(r.Actual, e.Sum) {
+				r.Status = StatusOK
+			}
+		}
+		sum.Total++
+		switch r.Status {
+		case StatusOK:
+			sum.OK++
+		case StatusMissing:
+			sum.Missing++
+		default:
+			sum.Failed++
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- r:
+		}
+		return nil
+	}
+
+	for expected != nil || actual != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case v, ok := <-expected:
+			if !ok {
+				expected = nil
+				continue
+			}
+			e, _ := v.(ChecksumEntry)
+			if q := results[e.Path]; len(q) > 0 {
+				if len(q) == 1 {
+					delete(results, e.Path)
+				} else {
+					results[e.Path] = q[1:]
+				}
+				if err := emit(e, q[0]); err != nil {
+					return err
+				}
+				continue
+			}
+			waiting[e.Path] = append(waiting[e.Path], e)
+		case v, ok := <-actual:
+			if !ok {
+				actual = nil
+				continue
+			}
+			path, c, ok := toComputed(v)
+			if !ok {
+				continue
+			}
+			// The source emits one path per entry, so results pair with
+			// entries one to one, even when a file is listed twice; both
+			// sides queue per path for whichever arrives first.
+			if q := waiting[path]; len(q) > 0 {
+				if len(q) == 1 {
+					delete(waiting, path)
+				} else {
+					waiting[path] = q[1:]
+				}
+				if err := emit(q[0], c); err != nil {
+					return err
+				}
+				continue
+			}
+			results[path] = append(results[path], c)
+		}
+	}
+
+	// Entries whose file never produced a result, e.g. skipped by an error policy.
+	for _, path := range slices.Sorted(maps.Keys(waiting)) {
+		for _, e := range waiting[path] {
+			if err := emit(e, computed{err: fmt.Errorf("no result computed: %w", fs.ErrNotExist)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if summaryOut != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case summaryOut <- sum:
+		}
+	}
+	if sum.Failed > 0 || sum.Missing > 0 {
+		return pipe.Deferred(fmt.Errorf("verification failed: %d computed checksum(s) did NOT match, %d listed file(s) missing", sum.Failed, sum.Missing))
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// ErrorMode selects what a node does with an item that failed to process.
+type ErrorMode string
+
+const (
+	// OnErrorFail returns the error from Start, cancelling the whole graph.
+	OnErrorFail ErrorMode = "fail"
+	// OnErrorSkip drops the failed item and carries on.
+	OnErrorSkip ErrorMode = "skip"
+	// OnErrorRetry runs the item again with backoff, then applies Then.
+	OnErrorRetry ErrorMode = "retry"
+	// OnErrorDeadLetter sends the item and its error to the dead-letter port.
+	OnErrorDeadLetter ErrorMode = "deadletter"
+)
+
+// DefaultDeadLetterPort is the output port used when ErrorPolicy.Port is empty.
+const DefaultDeadLetterPort = "deadletter"
+
+// ErrorPolicy configures item-level error handling for a node. The zero
+// value leaves failures to the node itself; MD5Hasher, for example, reports
+// them inside its results.
+type ErrorPolicy struct {
+	Mode ErrorMode
+	// Attempts is the total number of tries in retry mode (default 3).
+	Attempts int
+	// Backoff is the delay before the first retry; it doubles on every
+	// further retry up to MaxBackoff (defaults 100ms and 10s).
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Then is applied once retries are exhausted: fail (default), skip or deadletter.
+	Then ErrorMode
+	// Port is the dead-letter output port name (default "deadletter").
+	Port string
+}
+
+// DeadLetter is emitted on the dead-letter port for every rejected item.
+type DeadLetter struct {
+	Node     string
+	Item     any
+	Err      error
+	Attempts int
+}
+
+func (d DeadLetter) String() string {
+	return fmt.Sprintf("%s: %v: %v", d.Node, d.Item, d.Err)
+}
+
+// Validate reports unknown modes and retry fallbacks that make no sense.
+func (p ErrorPolicy) Validate() error {
+	switch p.Mode {
+	case "", OnErrorFail, OnErrorSkip, OnErrorDeadLetter:
+	case OnErrorRetry:
+		switch p.Then {
+		case "", OnErrorFail, OnErrorSkip, OnErrorDeadLetter:
+		default:
+			return fmt.Errorf("unknown on_error fallback %q", p.Then)
+		}
+	default:
+		return fmt.Errorf("unknown on_error policy %q", p.Mode)
+	}
+	return nil
+}
+
+// final is the mode applied once an item has definitely failed.
+func (p ErrorPolicy) final() ErrorMode {
+	if p.Mode != OnErrorRetry {
+		return p.Mode
+	}
+	if p.Then == "" {
+		return OnErrorFail
+	}
+	return p.Then
+}
+
+// DeadLetterPort returns the dead-letter port name, or "" when the policy
+// never dead-letters.
+func (p ErrorPolicy) DeadLetterPort() string {
+	if p.final() != OnErrorDeadLetter {
+		return ""
+	}
+	if p.Port == "" {
+		return DefaultDeadLetterPort
+	}
+	return p.Port
+}
+
+// Attempt calls fn once, or up to Attempts times with exponential backoff
+// in retry mode. It returns the number of tries and the last error, nil on
+// success.
+func (p ErrorPolicy) Attempt(ctx context.Context, fn func() error) (attempts int, err error) {
+	max := 1
+	if p.Mode == OnErrorRetry {
+		max = p.Attempts
+		if max <= 0 {
+			max = 3
+		}
+	}
+	delay := p.Backoff
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+	maxDelay := p.MaxBackoff
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Second
+	}
+	for attempts = 1; ; attempts++ {
+		if err = fn(); err == nil || attempts >= max {
+			return attempts, err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return attempts, err
+		case <-t.C:
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// Reject applies the node's error policy to an item that failed after the
+// given number of attempts. It returns nil when the item was skipped or
+// dead-lettered and the node should carry on; otherwise the node must
+// return the error from Start.
+func (b *BaseNode) Reject(ctx context.Context, item any, attempts int, err error) error {
+	b.rejects.Add(1)
+	switch b.OnError.final() {
+	case OnErrorSkip:
+		return nil
+	case OnErrorDeadLetter:
+		out, _ := b.GetOutput(b.OnError.DeadLetterPort())
+		if out == nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- DeadLetter{Node: b.IDValue, Item: item, Err: err, Attempts: attempts}:
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+// Ports adds the dead-letter output to the declared ports when the policy
+// needs one. Nodes that override Ports use it to keep that output.
+func (p ErrorPolicy) Ports(specs []PortSpec) []PortSpec {
+	port := p.DeadLetterPort()
+	if port == "" {
+		return specs
+	}
+	return append(slices.Clip(specs), PortSpec{
+		Name:     port,
+		Dir:      PortOut,
+		Required: true,
+		Type:     reflect.TypeFor[DeadLetter](),
+		Doc:      "items rejected by the error policy",
+	})
+}
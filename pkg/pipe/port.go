@@ -0,0 +1,98 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PortDir tells whether a port receives or emits items.
+type PortDir int
+
+const (
+	PortIn PortDir = iota
+	PortOut
+)
+
+func (d PortDir) String() string {
+	if d == PortOut {
+		return "out"
+	}
+	return "in"
+}
+
+// PortSpec declares a port that a node type accepts, independent of wiring.
+// Type is the payload carried by the port; nil means any value.
+// Lane groups ports that depend on each other: items entering a lane only
+// leave through the same lane, so a path that leaves a node on one lane and
+// comes back on another is not a cycle. Most nodes leave it empty.
+type PortSpec struct {
+	Name     string
+	Dir      PortDir
+	Required bool
+	Type     reflect.Type
+	Doc      string
+	Lane     string
+}
+
+// TypeName returns the payload type name, or "any" when unspecified.
+func (p PortSpec) TypeName() string {
+	if p.Type == nil {
+		return "any"
+	}
+	return p.Type.String()
+}
+
+func (p PortSpec) String() string {
+	req := "optional"
+	if p.Required {
+		req = "required"
+	}
+	s := fmt.Sprintf("%s (%s, %s, %s)", p.Name, p.Dir, req, p.TypeName())
+	if p.Doc != "" {
+		s += ": " + p.Doc
+	}
+	return s
+}
+
+// Accepts reports whether items emitted on out may be delivered to p.
+// Ports without a declared type accept and produce anything.
+func (p PortSpec) Accepts(out PortSpec) bool {
+	if p.Type == nil || out.Type == nil {
+		return true
+	}
+	return out.Type.AssignableTo(p.Type)
+}
+
+// DescribePorts renders port declarations one per line, inputs first.
+func DescribePorts(specs []PortSpec) string {
+	var b strings.Builder
+	for _, dir := range []PortDir{PortIn, PortOut} {
+		for _, p := range specs {
+			if p.Dir == dir {
+				b.WriteString(p.String())
+				b.WriteByte('\n')
+			}
+		}
+	}
+	return b.String()
+}
+
+func findPort(specs []PortSpec, name string, dir PortDir) (PortSpec, bool) {
+	for _, p := range specs {
+		if p.Name == name && p.Dir == dir {
+			return p, true
+		}
+	}
+	return PortSpec{}, false
+}
+
+func portNames(specs []PortSpec, dir PortDir) []string {
+	names := make([]string, 0, len(specs))
+	for _, p := range specs {
+		if p.Dir == dir {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
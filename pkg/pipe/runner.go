@@ -1,67 +1,256 @@
 package pipe
 
 import (
-    "context"
-    "fmt"
-    "sync"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// NodeError records the failure of a single node during a run.
+// First marks the root cause: the node that failed while the run was still
+// healthy. Canceled marks nodes that only stopped because the run had already
+// been cancelled, by another node's failure or by the caller.
+type NodeError struct {
+	NodeID   string
+	Err      error
+	First    bool
+	Canceled bool
+}
+
+func (e *NodeError) Error() string {
+	s := fmt.Sprintf("node %s: %v", e.NodeID, e.Err)
+	if e.Canceled {
+		s += " (cancelled)"
+	}
+	return s
+}
+
+func (e *NodeError) Unwrap() error { return e.Err }
+
+// RunError collects every node failure of a run in the order they happened.
+// It works with errors.Is and errors.As through each recorded NodeError.
+type RunError struct {
+	Nodes []*NodeError
+}
+
+func (e *RunError) Error() string {
+	msgs := make([]string, 0, len(e.Nodes))
+	for _, ne := range e.Nodes {
+		msgs = append(msgs, ne.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *RunError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Nodes))
+	for _, ne := range e.Nodes {
+		errs = append(errs, ne)
+	}
+	return errs
+}
+
+// Cause returns the root-cause failure, or nil when every node only
+// stopped because of cancellation.
+func (e *RunError) Cause() *NodeError {
+	for _, ne := range e.Nodes {
+		if ne.First {
+			return ne
+		}
+	}
+	return nil
+}
+
+// Failed returns the node failures that are not cancellation noise.
+func (e *RunError) Failed() []*NodeError {
+	var out []*NodeError
+	for _, ne := range e.Nodes {
+		if !ne.Canceled {
+			out = append(out, ne)
+		}
+	}
+	return out
+}
+
+// deferredError marks a failure that must not cancel the rest of the graph.
+type deferredError struct{ err error }
+
+func (e *deferredError) Error() string { return e.err.Error() }
+func (e *deferredError) Unwrap() error { return e.err }
+
+// Deferred wraps err so that returning it from Start fails the run without
+// cancelling the other nodes. Use it for failures detected after all output
+// was sent, such as a checksum mismatch, so downstream nodes still drain.
+func Deferred(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &deferredError{err: err}
+}
+
+func isCancellation(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 // Runner starts nodes, handles cancellation and waits for completion.
+//
+// DrainTimeout enables graceful stop: when the context passed to Run is
+// cancelled, only source nodes (nodes without incoming edges) are stopped,
+// and downstream nodes keep processing items already in flight. Once the
+// timeout elapses every node is cancelled. Zero cancels all nodes at once.
+//
+// Metrics, when set, is filled with per-node and per-edge counters during Run.
 type Runner struct {
-    g *Graph
+	g            *Graph
+	DrainTimeout time.Duration
+	Metrics      *Metrics
 }
 
 func NewRunner(g *Graph) *Runner { return &Runner{g: g} }
 
-func (r *Runner) Run(ctx context.Context) error {
-    if r.g == nil {
-        return fmt.Errorf("nil graph")
-    }
-    if err := r.g.materialize(); err != nil {
-        return err
-    }
-
-    ctx, cancel := context.WithCancel(ctx)
-    defer cancel()
-
-    var wg sync.WaitGroup
-    errs := make(chan error, len(r.g.nodes))
-
-    for _, n := range r.g.nodes {
-        wg.Add(1)
-        go func(n Node) {
-            defer wg.Done()
-            if err := n.Start(ctx); err != nil {
-                select {
-                case errs <- err:
-                default:
-                }
-                cancel()
-            }
-        }(n)
-    }
-
-    // Wait and ensure outputs are closed to unblock downstreams.
-    done := make(chan struct{})
-    go func() {
-        wg.Wait()
-        close(done)
-    }()
-
-    select {
-    case <-done:
-        // all good
-    case err := <-errs:
-        if err != nil {
-            <-done
-            return err
-        }
-    case <-ctx.Done():
-        <-done
-        return ctx.Err()
-    }
-
-    return nil
+// Run validates and wires the graph, starts every node and waits for all of
+// them. The first failing node cancels the rest; every failure is reported
+// in a *RunError. If parent is cancelled and the graph stops cleanly, Run
+// returns parent.Err().
+func (r *Runner) Run(parent context.Context) error {
+	if r.g == nil {
+		return fmt.Errorf("nil graph")
+	}
+	if err := r.g.Validate(); err != nil {
+		return err
+	}
+	if r.Metrics != nil {
+		r.Metrics.reset()
+	}
+	pumps, err := r.g.materialize(r.Metrics)
+	if err != nil {
+		return err
+	}
+
+	// ctx stops every node; srcCtx stops only the sources. Without a drain
+	// timeout both follow parent directly.
+	base := parent
+	if r.DrainTimeout > 0 {
+		base = context.WithoutCancel(parent)
+	}
+	var interrupted atomic.Bool
+	base = context.WithValue(base, interruptedKey{}, &interrupted)
+	ctx, cancel := context.WithCancel(base)
+	defer cancel()
+	srcCtx, srcCancel := context.WithCancel(ctx)
+	defer srcCancel()
+
+	finished := make(chan struct{})
+	if r.DrainTimeout > 0 {
+		go func() {
+			select {
+			case <-finished:
+				return
+			case <-parent.Done():
+			}
+			interrupted.Store(true)
+			srcCancel()
+			t := time.NewTimer(r.DrainTimeout)
+			defer t.Stop()
+			select {
+			case <-finished:
+			case <-t.C:
+				cancel()
+			}
+		}()
+	}
+
+	sources := r.g.sources()
+	var (
+		mu     sync.Mutex
+		runErr RunError
+		first  bool
+	)
+	fail := func(n Node, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, src := sources[n]; src && ctx.Err() == nil && srcCtx.Err() != nil && isCancellation(err) {
+			// Source stopped by a graceful drain: not a failure.
+			return
+		}
+		ne := &NodeError{NodeID: n.ID(), Err: err}
+		if ctx.Err() != nil || srcCtx.Err() != nil {
+			ne.Canceled = isCancellation(err)
+		}
+		if !ne.Canceled && !first {
+			ne.First = true
+			first = true
+		}
+		runErr.Nodes = append(runErr.Nodes, ne)
+		var d *deferredError
+		if !errors.As(err, &d) {
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, pump := range pumps {
+		wg.Add(1)
+		go func(pump func(ctx context.Context)) {
+			defer wg.Done()
+			pump(ctx)
+		}(pump)
+	}
+
+	for _, n := range r.g.nodes {
+		nctx := ctx
+		if _, src := sources[n]; src {
+			nctx = srcCtx
+		}
+		var ns *nodeStats
+		if r.Metrics != nil {
+			ns = r.Metrics.addNode(n)
+		}
+		wg.Add(1)
+		go func(n Node) {
+			defer wg.Done()
+			if ns != nil {
+				ns.start()
+			}
+			err := n.Start(nctx)
+			if ns != nil {
+				ns.stop(err)
+			}
+			if err != nil {
+				fail(n, err)
+			}
+		}(n)
+	}
+	wg.Wait()
+	close(finished)
+	for _, n := range r.g.nodes {
+		if c, ok := n.(Closer); ok {
+			if err := c.Close(); err != nil {
+				fail(n, err)
+			}
+		}
+	}
+
+	if len(runErr.Nodes) > 0 {
+		return &runErr
+	}
+	return parent.Err()
 }
 
+type interruptedKey struct{}
 
+// Interrupted reports whether the run of a node's context is stopping
+// early: the context is done, or the caller cancelled the run and nodes are
+// draining (see Runner.DrainTimeout), in which case inputs still close
+// cleanly. Nodes that commit their output at the end use it to discard a
+// partial result.
+func Interrupted(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	flag, _ := ctx.Value(interruptedKey{}).(*atomic.Bool)
+	return flag != nil && flag.Load()
+}
@@ -0,0 +1,205 @@
+package pipe
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CycleError reports a cycle in the graph wiring. Path lists node IDs along
+// the cycle, starting and ending with the same node.
+type CycleError struct {
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("cycle detected: %s (mark an edge as feedback to allow it)", strings.Join(e.Path, " -> "))
+}
+
+// vertex is one lane of a node. Nodes without lanes have a single vertex.
+type vertex struct {
+	n    Node
+	lane string
+}
+
+func laneOf(n Node, port string, dir PortDir) string {
+	p, _ := findPort(n.Ports(), port, dir)
+	return p.Lane
+}
+
+// vertices returns the lanes of every node in graph order, and the
+// downstream vertices of each one, skipping feedback edges. Order follows
+// declaration order so results are deterministic.
+func (g *Graph) vertices() ([]vertex, map[vertex][]vertex) {
+	var all []vertex
+	seen := make(map[vertex]struct{})
+	add := func(v vertex) {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			all = append(all, v)
+		}
+	}
+	for _, n := range g.nodes {
+		ports := n.Ports()
+		if len(ports) == 0 {
+			add(vertex{n: n})
+		}
+		for _, p := range ports {
+			add(vertex{n: n, lane: p.Lane})
+		}
+	}
+	next := make(map[vertex][]vertex, len(all))
+	for _, e := range g.edges {
+		from := vertex{e.from, laneOf(e.from, e.out, PortOut)}
+		to := vertex{e.to, laneOf(e.to, e.in, PortIn)}
+		add(from)
+		add(to)
+		if !e.Feedback {
+			next[from] = append(next[from], to)
+		}
+	}
+	return all, next
+}
+
+// sources returns the nodes that have no incoming edges.
+func (g *Graph) sources() map[Node]struct{} {
+	fed := make(map[Node]struct{}, len(g.edges))
+	for _, e := range g.edges {
+		fed[e.to] = struct{}{}
+	}
+	src := make(map[Node]struct{})
+	for _, n := range g.nodes {
+		if _, ok := fed[n]; !ok {
+			src[n] = struct{}{}
+		}
+	}
+	return src
+}
+
+// order sorts the vertices topologically.
+func (g *Graph) order() ([]vertex, map[vertex][]vertex, error) {
+	all, next := g.vertices()
+	indeg := make(map[vertex]int, len(all))
+	for _, succ := range next {
+		for _, v := range succ {
+			indeg[v]++
+		}
+	}
+	var queue, order []vertex
+	for _, v := range all {
+		if indeg[v] == 0 {
+			queue = append(queue, v)
+		}
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		order = append(order, v)
+		for _, w := range next[v] {
+			indeg[w]--
+			if indeg[w] == 0 {
+				queue = append(queue, w)
+			}
+		}
+	}
+	if len(order) < len(all) {
+		return nil, nil, findCycle(all, next)
+	}
+	return order, next, nil
+}
+
+// Order returns the nodes in topological order: every node comes after all
+// nodes feeding it. Feedback edges are ignored; any other cycle is reported
+// as a *CycleError. A node whose ports are split into independent lanes is
+// placed by its earliest lane.
+func (g *Graph) Order() ([]Node, error) {
+	order, _, err := g.order()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(g.nodes))
+	placed := make(map[Node]struct{}, len(g.nodes))
+	for _, v := range order {
+		if _, ok := placed[v.n]; !ok {
+			placed[v.n] = struct{}{}
+			nodes = append(nodes, v.n)
+		}
+	}
+	return nodes, nil
+}
+
+// Stages groups nodes by their distance from the sources: stage 0 holds the
+// nodes without upstream edges, stage N the nodes whose longest upstream path
+// has N edges.
+func (g *Graph) Stages() ([][]Node, error) {
+	order, next, err := g.order()
+	if err != nil {
+		return nil, err
+	}
+	level := make(map[vertex]int, len(order))
+	for _, v := range order {
+		for _, w := range next[v] {
+			level[w] = max(level[w], level[v]+1)
+		}
+	}
+	var stages [][]Node
+	placed := make(map[Node]struct{}, len(g.nodes))
+	for _, v := range order {
+		if _, ok := placed[v.n]; ok {
+			continue
+		}
+		placed[v.n] = struct{}{}
+		l := level[v]
+		for len(stages) <= l {
+			stages = append(stages, nil)
+		}
+		stages[l] = append(stages[l], v.n)
+	}
+	// A multi-lane node placed by its earliest lane can leave a level empty.
+	return slices.DeleteFunc(stages, func(s []Node) bool { return len(s) == 0 }), nil
+}
+
+// findCycle walks the vertices depth-first and returns the first cycle found.
+func findCycle(all []vertex, next map[vertex][]vertex) error {
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+	state := make(map[vertex]int, len(all))
+	var stack []vertex
+	var cycle []string
+
+	var visit func(v vertex) bool
+	visit = func(v vertex) bool {
+		state[v] = onStack
+		stack = append(stack, v)
+		for _, w := range next[v] {
+			switch state[w] {
+			case onStack:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == w {
+						for _, s := range stack[i:] {
+							cycle = append(cycle, s.n.ID())
+						}
+						cycle = append(cycle, w.n.ID())
+						return true
+					}
+				}
+			case unvisited:
+				if visit(w) {
+					return true
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[v] = done
+		return false
+	}
+	for _, v := range all {
+		if state[v] == unvisited && visit(v) {
+			return &CycleError{Path: cycle}
+		}
+	}
+	return &CycleError{}
+}